@@ -0,0 +1,157 @@
+package shandler
+
+import (
+	"context"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// AsyncPolicy decides what happens to a record when the async queue is full
+type AsyncPolicy int
+
+const (
+	// AsyncDropNewest drops the record being logged
+	AsyncDropNewest AsyncPolicy = iota
+	// AsyncDropOldest drops the oldest queued record to make room
+	AsyncDropOldest
+	// AsyncBlock waits until there is room in the queue
+	AsyncBlock
+)
+
+type asyncEntry struct {
+	writers []io.Writer
+	b       []byte
+}
+
+// asyncWriter writes formatted records from a background goroutine. It is
+// shared by a handler and every handler derived from it. Records are
+// formatted by the goroutine that logs them, only the writes are deferred.
+type asyncWriter struct {
+	mu     *sync.Mutex
+	policy AsyncPolicy
+
+	queue   chan asyncEntry
+	flushes chan chan struct{}
+	done    chan struct{}
+
+	// closeMu guards closed. Senders hold it for reading so the queue is
+	// never closed while a send is in progress
+	closeMu sync.RWMutex
+	closed  bool
+
+	dropped atomic.Uint64
+}
+
+func newAsyncWriter(mu *sync.Mutex, size int, policy AsyncPolicy) *asyncWriter {
+	a := &asyncWriter{
+		mu:      mu,
+		policy:  policy,
+		queue:   make(chan asyncEntry, size),
+		flushes: make(chan chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go a.run()
+	return a
+}
+
+func (a *asyncWriter) run() {
+	defer close(a.done)
+	for {
+		select {
+		case e, ok := <-a.queue:
+			if !ok {
+				return
+			}
+			_ = writeAll(a.mu, e.writers, e.b)
+		case flushed := <-a.flushes:
+			a.drain()
+			close(flushed)
+		}
+	}
+}
+
+// drain writes the records that are queued when it is called
+func (a *asyncWriter) drain() {
+	for n := len(a.queue); n > 0; n-- {
+		select {
+		case e, ok := <-a.queue:
+			if !ok {
+				return
+			}
+			_ = writeAll(a.mu, e.writers, e.b)
+		default:
+			return
+		}
+	}
+}
+
+// enqueue queues b for writing. It returns false once the writer is closed,
+// in which case the caller writes synchronously
+func (a *asyncWriter) enqueue(writers []io.Writer, b []byte) bool {
+	a.closeMu.RLock()
+	defer a.closeMu.RUnlock()
+
+	if a.closed {
+		return false
+	}
+
+	// b may be a pooled buffer that is reused once Handle returns
+	e := asyncEntry{writers: writers, b: append([]byte(nil), b...)}
+
+	switch a.policy {
+	case AsyncBlock:
+		a.queue <- e
+	case AsyncDropOldest:
+		for {
+			select {
+			case a.queue <- e:
+				return true
+			default:
+			}
+			select {
+			case <-a.queue:
+				a.dropped.Add(1)
+			default:
+			}
+		}
+	default:
+		select {
+		case a.queue <- e:
+		default:
+			a.dropped.Add(1)
+		}
+	}
+	return true
+}
+
+// flush waits until every record queued before it was called is written
+func (a *asyncWriter) flush(ctx context.Context) error {
+	flushed := make(chan struct{})
+	select {
+	case a.flushes <- flushed:
+	case <-a.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case <-flushed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// close stops accepting records and waits for the queue to drain
+func (a *asyncWriter) close() {
+	a.closeMu.Lock()
+	if !a.closed {
+		a.closed = true
+		close(a.queue)
+	}
+	a.closeMu.Unlock()
+
+	<-a.done
+}
@@ -0,0 +1,194 @@
+package shandler
+
+import (
+	"log/slog"
+	"slices"
+	"strings"
+)
+
+// groupOrAttrs holds either a group name or a list of attributes, in the
+// order they were added to the handler through WithGroup and WithAttrs
+type groupOrAttrs struct {
+	group string
+	attrs []slog.Attr
+}
+
+// collectAttrs builds the attribute tree for a record. Attributes added with
+// WithAttrs are nested under the groups that were open when they were added
+// and the record attributes are nested under every open group. Groups that
+// end up without any attributes are dropped.
+func (n *Handler) collectAttrs(record slog.Record) []slog.Attr {
+	return collectAttrs(n.goas, record)
+}
+
+func collectAttrs(goas []groupOrAttrs, record slog.Record) []slog.Attr {
+	attrs := make([]slog.Attr, 0, record.NumAttrs())
+	record.Attrs(func(a slog.Attr) bool {
+		attrs = append(attrs, a)
+		return true
+	})
+	attrs = normalizeAttrs(attrs)
+
+	for i := len(goas) - 1; i >= 0; i-- {
+		goa := goas[i]
+		if goa.group != "" {
+			if len(attrs) == 0 {
+				continue
+			}
+			attrs = []slog.Attr{{Key: goa.group, Value: slog.GroupValue(attrs...)}}
+		} else {
+			attrs = append(slices.Clip(goa.attrs), attrs...)
+		}
+	}
+	return attrs
+}
+
+// appendRecordAttrs appends the attributes of record to attrs, normalized
+// like normalizeAttrs. Only the attributes needing it go through
+// normalizeAttrs so attrs doesn't escape and can be backed by an array on
+// the stack
+func appendRecordAttrs(attrs []slog.Attr, record slog.Record) []slog.Attr {
+	record.Attrs(func(a slog.Attr) bool {
+		if needsNormalize(a) {
+			attrs = append(attrs, normalizeAttrs([]slog.Attr{a})...)
+		} else {
+			attrs = append(attrs, a)
+		}
+		return true
+	})
+	return attrs
+}
+
+// normalizeAttrs resolves any slog.LogValuer values and applies the
+// slog.Handler rules for attributes: empty attrs and empty groups are
+// dropped and groups with an empty key are inlined into their parent
+func normalizeAttrs(attrs []slog.Attr) []slog.Attr {
+	if !slices.ContainsFunc(attrs, needsNormalize) {
+		return attrs
+	}
+
+	ret := make([]slog.Attr, 0, len(attrs))
+	for _, a := range attrs {
+		a.Value = a.Value.Resolve()
+		if a.Equal(slog.Attr{}) {
+			continue
+		}
+		if a.Value.Kind() == slog.KindGroup {
+			group := normalizeAttrs(a.Value.Group())
+			if len(group) == 0 {
+				continue
+			}
+			if a.Key == "" {
+				ret = append(ret, group...)
+				continue
+			}
+			a.Value = slog.GroupValue(group...)
+		}
+		ret = append(ret, a)
+	}
+	return ret
+}
+
+func needsNormalize(a slog.Attr) bool {
+	switch a.Value.Kind() {
+	case slog.KindGroup, slog.KindLogValuer:
+		return true
+	}
+	return a.Key == ""
+}
+
+// textAttrs flattens attrs into key=value pairs, joining nested
+// group keys with a dot
+func textAttrs(prefix string, attrs []slog.Attr, out []string) []string {
+	for _, a := range attrs {
+		if a.Value.Kind() == slog.KindGroup {
+			out = textAttrs(prefix+a.Key+".", a.Value.Group(), out)
+			continue
+		}
+		out = append(out, prefix+a.Key+"="+a.Value.String())
+	}
+	return out
+}
+
+// textPrefix holds the attributes added with WithAttrs flattened into
+// key=value pairs when the handler is derived, for text output
+type textPrefix struct {
+	pairs []string
+}
+
+// with returns a copy of p with goa added. group is the group path the
+// attributes of goa are added in
+func (p *textPrefix) with(goa groupOrAttrs, group string) *textPrefix {
+	if goa.group != "" {
+		return p
+	}
+	return &textPrefix{pairs: textAttrs(groupPrefix(group), goa.attrs, slices.Clip(p.pairs))}
+}
+
+// append returns the pairs of p followed by the pairs of the record attrs,
+// nested in group
+func (p *textPrefix) append(group string, attrs []slog.Attr) []string {
+	return textAttrs(groupPrefix(group), attrs, slices.Clip(p.pairs))
+}
+
+// groupPrefix returns the prefix of the keys of the attributes in group
+func groupPrefix(group string) string {
+	if group == "" {
+		return ""
+	}
+	return group + "."
+}
+
+func groupPath(goas []groupOrAttrs) string {
+	groups := []string{}
+	for _, goa := range goas {
+		if goa.group != "" {
+			groups = append(groups, goa.group)
+		}
+	}
+	return strings.Join(groups, ".")
+}
+
+// replaceAttrs calls replace on every non-group attribute in attrs, passing
+// the names of the groups it is nested in. Attributes replaced with an empty
+// key are dropped, as are groups left without any attributes.
+func replaceAttrs(replace func([]string, slog.Attr) slog.Attr, groups []string, attrs []slog.Attr) []slog.Attr {
+	ret := make([]slog.Attr, 0, len(attrs))
+	for _, a := range attrs {
+		if a.Value.Kind() == slog.KindGroup {
+			group := replaceAttrs(replace, append(slices.Clip(groups), a.Key), a.Value.Group())
+			if len(group) != 0 {
+				ret = append(ret, slog.Attr{Key: a.Key, Value: slog.GroupValue(group...)})
+			}
+			continue
+		}
+
+		a = replace(groups, a)
+		a.Value = a.Value.Resolve()
+		if a.Key == "" {
+			continue
+		}
+		ret = append(ret, a)
+	}
+	return ret
+}
+
+// replaceBuiltin applies the ReplaceAttr hook to one of the built-in level,
+// time or message attributes. The returned key is the handler's own name for
+// the field unless the hook renamed the attribute. It returns false if the
+// hook dropped the attribute.
+func (n *Handler) replaceBuiltin(a slog.Attr, key string) (string, slog.Value, bool) {
+	if n.replaceAttr == nil {
+		return key, a.Value, true
+	}
+
+	origKey := a.Key
+	a = n.replaceAttr(nil, a)
+	if a.Key == "" {
+		return "", slog.Value{}, false
+	}
+	if a.Key != origKey {
+		key = a.Key
+	}
+	return key, a.Value.Resolve(), true
+}
@@ -0,0 +1,86 @@
+// Command pseudonym derives the token WithPseudonymization writes for a known
+// value, so records about a person can be found during an investigation
+// without the logs holding their data.
+//
+//	SHANDLER_PSEUDONYM_KEY=... pseudonym -key-id 2024-01 bob@example.com
+//	pseudonym -key-id 2024-01 -key-file key.txt < emails.txt
+//	pseudonym -key-id 2024-01 -verify 2024-01:5e0b... bob@example.com
+//
+// Values are read from the arguments, or one per line from stdin. The key
+// is read from -key-file, or from the environment variable named by -key-env
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	handler "disorder.dev/shandler"
+)
+
+func main() {
+	if err := run(os.Args[1:], os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "pseudonym:", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string, stdin io.Reader, stdout io.Writer) error {
+	fs := flag.NewFlagSet("pseudonym", flag.ContinueOnError)
+	keyID := fs.String("key-id", "", "ID of the key the tokens were derived with, required")
+	keyFile := fs.String("key-file", "", "file holding the key, a trailing newline is ignored")
+	keyEnv := fs.String("key-env", "SHANDLER_PSEUDONYM_KEY", "environment variable holding the key")
+	verify := fs.String("verify", "", "check that this token was derived from the value instead of printing tokens")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *keyID == "" {
+		return errors.New("-key-id is required")
+	}
+
+	var secret []byte
+	if *keyFile != "" {
+		b, err := os.ReadFile(*keyFile)
+		if err != nil {
+			return err
+		}
+		secret = bytes.TrimRight(b, "\r\n")
+	} else {
+		secret = []byte(os.Getenv(*keyEnv))
+	}
+	if len(secret) == 0 {
+		return errors.New("no key, set -key-file or " + *keyEnv)
+	}
+	key := handler.PseudonymKey{ID: *keyID, Secret: secret}
+
+	values := fs.Args()
+	if len(values) == 0 {
+		scanner := bufio.NewScanner(stdin)
+		for scanner.Scan() {
+			values = append(values, scanner.Text())
+		}
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+	}
+
+	if *verify != "" {
+		p := handler.NewPseudonymizer(key)
+		for _, v := range values {
+			if p.Verify(*verify, v) {
+				fmt.Fprintln(stdout, v)
+				return nil
+			}
+		}
+		return errors.New("no value matches " + *verify)
+	}
+
+	for _, v := range values {
+		fmt.Fprintln(stdout, handler.PseudonymToken(key, v))
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	handler "disorder.dev/shandler"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRun(t *testing.T) {
+	key := handler.PseudonymKey{ID: "2024", Secret: []byte("secret")}
+	t.Setenv("SHANDLER_PSEUDONYM_KEY", "secret")
+
+	var stdout bytes.Buffer
+	assert.Nil(t, run([]string{"-key-id", "2024", "bob@example.com", "alice@example.com"}, nil, &stdout))
+	assert.Equal(t, handler.PseudonymToken(key, "bob@example.com")+"\n"+handler.PseudonymToken(key, "alice@example.com")+"\n", stdout.String())
+
+	// values from stdin, the key from a file
+	keyFile := filepath.Join(t.TempDir(), "key.txt")
+	assert.Nil(t, os.WriteFile(keyFile, []byte("secret\n"), 0o600))
+	stdout.Reset()
+	assert.Nil(t, run([]string{"-key-id", "2024", "-key-file", keyFile}, strings.NewReader("bob@example.com\n"), &stdout))
+	assert.Equal(t, handler.PseudonymToken(key, "bob@example.com")+"\n", stdout.String())
+
+	stdout.Reset()
+	token := handler.PseudonymToken(key, "bob@example.com")
+	assert.Nil(t, run([]string{"-key-id", "2024", "-verify", token, "alice@example.com", "bob@example.com"}, nil, &stdout))
+	assert.Equal(t, "bob@example.com\n", stdout.String())
+	assert.NotNil(t, run([]string{"-key-id", "2024", "-verify", token, "alice@example.com"}, nil, &stdout))
+}
+
+func TestRunErrors(t *testing.T) {
+	t.Setenv("SHANDLER_PSEUDONYM_KEY", "")
+
+	var stdout bytes.Buffer
+	assert.EqualError(t, run([]string{"-key-id", "2024", "bob@example.com"}, nil, &stdout), "no key, set -key-file or SHANDLER_PSEUDONYM_KEY")
+
+	t.Setenv("SHANDLER_PSEUDONYM_KEY", "secret")
+	assert.EqualError(t, run([]string{"bob@example.com"}, nil, &stdout), "-key-id is required")
+	assert.Empty(t, stdout.String())
+}
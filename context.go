@@ -0,0 +1,42 @@
+package shandler
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+)
+
+type contextAttrsKey struct{}
+
+// ContextWith returns a copy of ctx carrying attrs in addition to the ones
+// already added to it. The handler appends them to every record logged with
+// that context, e.g. with slog.InfoContext
+func ContextWith(ctx context.Context, attrs ...slog.Attr) context.Context {
+	if len(attrs) == 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, contextAttrsKey{}, slices.Concat(ContextAttrs(ctx), attrs))
+}
+
+// ContextAttrs returns the attributes added to ctx with ContextWith
+func ContextAttrs(ctx context.Context) []slog.Attr {
+	if ctx == nil {
+		return nil
+	}
+	attrs, _ := ctx.Value(contextAttrsKey{}).([]slog.Attr)
+	return attrs
+}
+
+// contextAttrs returns the attributes added to ctx with ContextWith followed
+// by the ones returned by the WithContextExtractor functions
+func (n *Handler) contextAttrs(ctx context.Context) []slog.Attr {
+	if ctx == nil {
+		return nil
+	}
+
+	attrs := ContextAttrs(ctx)
+	for _, extract := range n.contextExtractors {
+		attrs = append(slices.Clip(attrs), extract(ctx)...)
+	}
+	return normalizeAttrs(attrs)
+}
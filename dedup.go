@@ -0,0 +1,187 @@
+package shandler
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// deduper collapses runs of identical records for WithDedup. It is shared by
+// a handler and every handler derived from it
+type deduper struct {
+	root   *Handler
+	window time.Duration
+
+	mu sync.Mutex
+	// run is the record that started the current run, nil when there is none
+	run     *dedupRun
+	start   time.Time
+	repeats int
+	// timer ends the run when the window expires, gen tells a timer that
+	// fires late that its run is already over
+	timer *time.Timer
+	gen   uint64
+
+	now func() time.Time
+}
+
+// dedupRun holds what identifies the record that started a run. The key of
+// its attributes is only built once a record with the same level, message
+// and group is logged
+type dedupRun struct {
+	level slog.Level
+	msg   string
+	group string
+
+	goas     []groupOrAttrs
+	record   slog.Record
+	ctxAttrs []slog.Attr
+	attrs    *string
+}
+
+// repeatRun is a finished run of identical records
+type repeatRun struct {
+	level   slog.Level
+	group   string
+	repeats int
+}
+
+func newDeduper(root *Handler, window time.Duration) *deduper {
+	return &deduper{root: root, window: window, now: time.Now}
+}
+
+// newDedupRun keeps what is needed to compare record with the records logged
+// after it, ctx is only used for the attributes it adds
+func (n *Handler) newDedupRun(ctx context.Context, record slog.Record) *dedupRun {
+	ctxAttrs := n.contextAttrs(ctx)
+	ctxAttrs = append(ctxAttrs, n.traceAttrs(ctx)...)
+	return &dedupRun{
+		level:    record.Level,
+		msg:      record.Message,
+		group:    n.group,
+		goas:     n.goas,
+		record:   record.Clone(),
+		ctxAttrs: ctxAttrs,
+	}
+}
+
+// attrsKey identifies the attributes of the run record, including the ones
+// taken from its context
+func (r *dedupRun) attrsKey() string {
+	if r.attrs != nil {
+		return *r.attrs
+	}
+	attrs := collectAttrs(r.goas, r.record)
+	attrs = append(attrs, r.ctxAttrs...)
+	sb := strings.Builder{}
+	for _, a := range textAttrs("", attrs, nil) {
+		sb.WriteByte(0)
+		sb.WriteString(a)
+	}
+	key := sb.String()
+	r.attrs = &key
+	return key
+}
+
+// repeats reports whether next, logged after the run record, is identical to
+// it
+func (r *dedupRun) repeats(next *dedupRun) bool {
+	if r.level != next.level || r.msg != next.msg || r.group != next.group {
+		return false
+	}
+	return r.attrsKey() == next.attrsKey()
+}
+
+// allow reports whether record is logged, false when it repeats the record
+// that started the current run
+func (d *deduper) allow(ctx context.Context, n *Handler, record slog.Record) bool {
+	d.mu.Lock()
+	now := d.now()
+	inWindow := d.window <= 0 || now.Sub(d.start) < d.window
+	// the attribute keys are only built once level, message and group match
+	match := d.run != nil && inWindow && record.Level == d.run.level && record.Message == d.run.msg && n.group == d.run.group
+	d.mu.Unlock()
+
+	next := n.newDedupRun(ctx, record)
+
+	d.mu.Lock()
+	if match && d.run != nil && d.run.repeats(next) {
+		d.repeats++
+		if d.repeats == 1 && d.window > 0 {
+			gen := d.gen
+			d.timer = time.AfterFunc(d.start.Add(d.window).Sub(now), func() {
+				d.expire(gen)
+			})
+		}
+		d.mu.Unlock()
+		return false
+	}
+	d.startRun(next, now)
+	return true
+}
+
+// startRun ends the current run and starts a new one with run. It must be
+// called with d.mu held and releases it
+func (d *deduper) startRun(run *dedupRun, now time.Time) {
+	ended := d.takeRun()
+	d.run = run
+	d.start = now
+	d.mu.Unlock()
+
+	d.report(ended)
+}
+
+// expire ends the run started by generation gen once its window is over
+func (d *deduper) expire(gen uint64) {
+	d.mu.Lock()
+	if gen != d.gen {
+		d.mu.Unlock()
+		return
+	}
+	run := d.takeRun()
+	d.run = nil
+	d.mu.Unlock()
+
+	d.report(run)
+}
+
+// flush ends the current run, reporting its repeats
+func (d *deduper) flush() {
+	d.mu.Lock()
+	run := d.takeRun()
+	d.run = nil
+	d.mu.Unlock()
+
+	d.report(run)
+}
+
+func (d *deduper) takeRun() repeatRun {
+	if d.timer != nil {
+		d.timer.Stop()
+		d.timer = nil
+	}
+	d.gen++
+
+	var run repeatRun
+	if d.run != nil {
+		run = repeatRun{level: d.run.level, group: d.run.group, repeats: d.repeats}
+	}
+	d.repeats = 0
+	return run
+}
+
+// report logs the syslog style "last message repeated N times" record
+// through the root handler
+func (d *deduper) report(run repeatRun) {
+	if run.repeats == 0 {
+		return
+	}
+	record := slog.NewRecord(d.now(), run.level, fmt.Sprintf("last message repeated %d times", run.repeats), 0)
+	if run.group != "" {
+		record.AddAttrs(slog.String("group", run.group))
+	}
+	_ = d.root.handle(context.Background(), record)
+}
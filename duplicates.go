@@ -0,0 +1,85 @@
+package shandler
+
+import (
+	"log/slog"
+	"strconv"
+)
+
+// DuplicateKeyPolicy controls what the JSON output does when the same key
+// appears more than once in an object
+type DuplicateKeyPolicy int
+
+const (
+	// DuplicateKeysAllow writes every attribute, repeating the key, the same
+	// as slog.JSONHandler. This is the default
+	DuplicateKeysAllow DuplicateKeyPolicy = iota
+	// DuplicateKeysKeepLast keeps the value of the last attribute with a
+	// given key, at the position of the first one
+	DuplicateKeysKeepLast
+	// DuplicateKeysKeepFirst keeps only the first attribute with a given key
+	DuplicateKeysKeepFirst
+	// DuplicateKeysSuffix renames repeated keys to key#2, key#3, ..., skipping
+	// the keys already in use
+	DuplicateKeysSuffix
+	// DuplicateKeysArray merges the values of repeated keys into an array
+	// written at the position of the first one
+	DuplicateKeysArray
+)
+
+// valueList holds the merged values of a repeated key for DuplicateKeysArray
+type valueList []slog.Value
+
+// dedupeAttrs applies policy to attrs and, recursively, to every group
+func dedupeAttrs(policy DuplicateKeyPolicy, attrs []slog.Attr) []slog.Attr {
+	if policy == DuplicateKeysAllow {
+		return attrs
+	}
+
+	ret := make([]slog.Attr, 0, len(attrs))
+	index := make(map[string]int, len(attrs))
+	counts := make(map[string]int)
+	var literal map[string]bool
+	for _, a := range attrs {
+		if a.Value.Kind() == slog.KindGroup {
+			a.Value = slog.GroupValue(dedupeAttrs(policy, a.Value.Group())...)
+		}
+
+		i, dup := index[a.Key]
+		if !dup {
+			index[a.Key] = len(ret)
+			ret = append(ret, a)
+			continue
+		}
+
+		switch policy {
+		case DuplicateKeysKeepLast:
+			ret[i] = a
+		case DuplicateKeysSuffix:
+			// skip the suffixes already used as keys, by earlier or later
+			// attributes, e.g. a, a, a#2 becomes a, a#3, a#2
+			if literal == nil {
+				literal = make(map[string]bool, len(attrs))
+				for _, la := range attrs {
+					literal[la.Key] = true
+				}
+			}
+			key := a.Key
+			for {
+				counts[key]++
+				a.Key = key + "#" + strconv.Itoa(counts[key]+1)
+				if _, seen := index[a.Key]; !seen && !literal[a.Key] {
+					break
+				}
+			}
+			index[a.Key] = len(ret)
+			ret = append(ret, a)
+		case DuplicateKeysArray:
+			list, ok := ret[i].Value.Any().(valueList)
+			if !ok {
+				list = valueList{ret[i].Value}
+			}
+			ret[i].Value = slog.AnyValue(append(list, a.Value))
+		}
+	}
+	return ret
+}
@@ -0,0 +1,242 @@
+package shandler
+
+import (
+	"encoding/json"
+	"log/slog"
+	"math"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const hex = "0123456789abcdef"
+
+// bufPool holds the byte buffers records are encoded into. Buffers that grew
+// too large are dropped instead of being returned to the pool.
+var bufPool = sync.Pool{
+	New: func() any {
+		b := make([]byte, 0, 1024)
+		return &b
+	},
+}
+
+const maxPooledBuffer = 16 << 10
+
+func getBuffer() *[]byte {
+	return bufPool.Get().(*[]byte)
+}
+
+func putBuffer(b *[]byte) {
+	if cap(*b) > maxPooledBuffer {
+		return
+	}
+	*b = (*b)[:0]
+	bufPool.Put(b)
+}
+
+// appendJSONKey writes "key": preceded by a comma unless it is the first key
+// of the object
+func appendJSONKey(buf []byte, key string) []byte {
+	if last := buf[len(buf)-1]; last != '{' {
+		buf = append(buf, ',')
+	}
+	buf = appendJSONString(buf, key)
+	return append(buf, ':')
+}
+
+// appendJSONAttrs writes attrs as the members of a JSON object, in order.
+// Groups become nested objects.
+func appendJSONAttrs(buf []byte, attrs []slog.Attr) []byte {
+	for _, a := range attrs {
+		buf = appendJSONKey(buf, a.Key)
+		buf = appendJSONValue(buf, a.Value)
+	}
+	return buf
+}
+
+func appendJSONValue(buf []byte, v slog.Value) []byte {
+	switch v.Kind() {
+	case slog.KindString:
+		return appendJSONString(buf, v.String())
+	case slog.KindInt64:
+		return strconv.AppendInt(buf, v.Int64(), 10)
+	case slog.KindUint64:
+		return strconv.AppendUint(buf, v.Uint64(), 10)
+	case slog.KindFloat64:
+		f := v.Float64()
+		// JSON has no representation for NaN and infinity
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return appendJSONString(buf, strconv.FormatFloat(f, 'g', -1, 64))
+		}
+		return strconv.AppendFloat(buf, f, 'g', -1, 64)
+	case slog.KindBool:
+		return strconv.AppendBool(buf, v.Bool())
+	case slog.KindDuration:
+		return strconv.AppendInt(buf, int64(v.Duration()), 10)
+	case slog.KindTime:
+		buf = append(buf, '"')
+		buf = v.Time().AppendFormat(buf, time.RFC3339Nano)
+		return append(buf, '"')
+	case slog.KindGroup:
+		buf = append(buf, '{')
+		buf = appendJSONAttrs(buf, v.Group())
+		return append(buf, '}')
+	default:
+		return appendJSONAny(buf, v.Any())
+	}
+}
+
+func appendJSONAny(buf []byte, a any) []byte {
+	switch a := a.(type) {
+	case nil:
+		return append(buf, "null"...)
+	case valueList:
+		buf = append(buf, '[')
+		for i, v := range a {
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+			buf = appendJSONValue(buf, v)
+		}
+		return append(buf, ']')
+	case json.Marshaler:
+		// encoded by json.Marshal below
+	case error:
+		return appendJSONString(buf, a.Error())
+	case []byte:
+		return appendJSONString(buf, string(a))
+	}
+
+	b, err := json.Marshal(a)
+	if err != nil {
+		return appendJSONString(buf, "!ERROR:"+err.Error())
+	}
+	return append(buf, b...)
+}
+
+// appendJSONString writes s as a quoted JSON string. It follows the escaping
+// rules of encoding/json without escaping HTML characters.
+func appendJSONString(buf []byte, s string) []byte {
+	buf = append(buf, '"')
+	start := 0
+	for i := 0; i < len(s); {
+		if b := s[i]; b < utf8.RuneSelf {
+			if b >= ' ' && b != '"' && b != '\\' {
+				i++
+				continue
+			}
+			buf = append(buf, s[start:i]...)
+			switch b {
+			case '"', '\\':
+				buf = append(buf, '\\', b)
+			case '\n':
+				buf = append(buf, '\\', 'n')
+			case '\r':
+				buf = append(buf, '\\', 'r')
+			case '\t':
+				buf = append(buf, '\\', 't')
+			default:
+				buf = append(buf, '\\', 'u', '0', '0', hex[b>>4], hex[b&0xF])
+			}
+			i++
+			start = i
+			continue
+		}
+
+		c, size := utf8.DecodeRuneInString(s[i:])
+		if c == utf8.RuneError && size == 1 {
+			buf = append(buf, s[start:i]...)
+			buf = append(buf, `\ufffd`...)
+			i += size
+			start = i
+			continue
+		}
+		// U+2028 and U+2029 are valid JSON but break JavaScript parsers
+		if c == '\u2028' || c == '\u2029' {
+			buf = append(buf, s[start:i]...)
+			buf = append(buf, '\\', 'u', '2', '0', '2', hex[c&0xF])
+			i += size
+			start = i
+			continue
+		}
+		i += size
+	}
+	buf = append(buf, s[start:]...)
+	return append(buf, '"')
+}
+
+// jsonPrefix holds the attributes added with WithAttrs, encoded as JSON
+// object members when the handler is derived so they are not encoded again
+// for every record. attrs[0] holds the top level members and attrs[i] the
+// members of groups[i-1], the i-th group opened with WithGroup
+type jsonPrefix struct {
+	groups []string
+	attrs  [][]byte
+}
+
+func newJSONPrefix() *jsonPrefix {
+	return &jsonPrefix{attrs: [][]byte{nil}}
+}
+
+// with returns a copy of p with goa added, p is shared by other handlers and
+// is left untouched
+func (p *jsonPrefix) with(goa groupOrAttrs) *jsonPrefix {
+	np := &jsonPrefix{groups: slices.Clip(p.groups), attrs: slices.Clone(p.attrs)}
+	if goa.group != "" {
+		np.groups = append(np.groups, goa.group)
+		np.attrs = append(np.attrs, nil)
+		return np
+	}
+
+	last := len(np.attrs) - 1
+	members := slices.Clip(np.attrs[last])
+	for _, a := range goa.attrs {
+		if len(members) != 0 {
+			members = append(members, ',')
+		}
+		members = appendJSONString(members, a.Key)
+		members = append(members, ':')
+		members = appendJSONValue(members, a.Value)
+	}
+	np.attrs[last] = members
+	return np
+}
+
+// appendJSONTree writes the "attrs" member of a record: the pre-encoded
+// attributes of p with attrs, the record attributes, nested under every open
+// group, followed by the top level extra attributes. Like collectAttrs, it
+// leaves out groups that end up without any attributes
+func appendJSONTree(buf []byte, p *jsonPrefix, attrs, extra []slog.Attr) []byte {
+	depth := len(p.groups)
+	if len(attrs) == 0 {
+		for depth > 0 && len(p.attrs[depth]) == 0 {
+			depth--
+		}
+	}
+	if depth == 0 && len(p.attrs[0]) == 0 && len(attrs) == 0 && len(extra) == 0 {
+		return buf
+	}
+
+	buf = appendJSONKey(buf, "attrs")
+	buf = append(buf, '{')
+	for i := 0; i <= depth; i++ {
+		if i > 0 {
+			buf = appendJSONKey(buf, p.groups[i-1])
+			buf = append(buf, '{')
+		}
+		if len(p.attrs[i]) != 0 {
+			if buf[len(buf)-1] != '{' {
+				buf = append(buf, ',')
+			}
+			buf = append(buf, p.attrs[i]...)
+		}
+	}
+	buf = appendJSONAttrs(buf, attrs)
+	for i := 0; i < depth; i++ {
+		buf = append(buf, '}')
+	}
+	buf = appendJSONAttrs(buf, extra)
+	return append(buf, '}')
+}
@@ -0,0 +1,104 @@
+package shandler
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// maxErrorDepth bounds how far WithRichErrors follows wrapped and joined
+// errors, in case an error unwraps to itself
+const maxErrorDepth = 32
+
+// errorValue is an error as written by WithRichErrors. Chain holds the errors
+// returned by repeated calls to Unwrap() error, Errors the members of an
+// error implementing Unwrap() []error such as the ones from errors.Join
+type errorValue struct {
+	Message string       `json:"msg"`
+	Type    string       `json:"type,omitempty"`
+	Chain   []errorValue `json:"chain,omitempty"`
+	Errors  []errorValue `json:"errors,omitempty"`
+}
+
+func newErrorValue(err error, typeNames bool, depth int) errorValue {
+	v := newErrorLink(err, typeNames, depth)
+	for cur := err; len(v.Chain) < maxErrorDepth; {
+		u, ok := cur.(interface{ Unwrap() error })
+		if !ok {
+			break
+		}
+		if cur = u.Unwrap(); cur == nil {
+			break
+		}
+		v.Chain = append(v.Chain, newErrorLink(cur, typeNames, depth))
+	}
+	return v
+}
+
+// newErrorLink returns err without its chain, joined errors are expanded
+func newErrorLink(err error, typeNames bool, depth int) errorValue {
+	v := errorValue{Message: err.Error()}
+	if typeNames {
+		v.Type = fmt.Sprintf("%T", err)
+	}
+	if u, ok := err.(interface{ Unwrap() []error }); ok && depth < maxErrorDepth {
+		for _, e := range u.Unwrap() {
+			if e != nil {
+				v.Errors = append(v.Errors, newErrorValue(e, typeNames, depth+1))
+			}
+		}
+	}
+	return v
+}
+
+// String writes the error and its chain on a single line for the text and
+// logfmt output, e.g.
+// read config: open app.yaml: not found (*fmt.wrapError) -> open app.yaml: not found (*fs.PathError) -> not found (syscall.Errno)
+func (e errorValue) String() string {
+	sb := strings.Builder{}
+	e.writeLink(&sb)
+	for _, c := range e.Chain {
+		sb.WriteString(" -> ")
+		c.writeLink(&sb)
+	}
+	return sb.String()
+}
+
+func (e errorValue) writeLink(sb *strings.Builder) {
+	if len(e.Errors) > 0 {
+		// the message of joined errors spans several lines, list the members
+		sb.WriteByte('[')
+		for i, m := range e.Errors {
+			if i > 0 {
+				sb.WriteString("; ")
+			}
+			sb.WriteString(m.String())
+		}
+		sb.WriteByte(']')
+	} else {
+		sb.WriteString(e.Message)
+	}
+	if e.Type != "" {
+		sb.WriteString(" (")
+		sb.WriteString(e.Type)
+		sb.WriteByte(')')
+	}
+}
+
+// richErrors replaces the error values in attrs, and in their groups, with
+// their errorValue
+func richErrors(attrs []slog.Attr, typeNames bool) []slog.Attr {
+	ret := make([]slog.Attr, 0, len(attrs))
+	for _, a := range attrs {
+		switch a.Value.Kind() {
+		case slog.KindGroup:
+			a.Value = slog.GroupValue(richErrors(a.Value.Group(), typeNames)...)
+		case slog.KindAny:
+			if err, ok := a.Value.Any().(error); ok && err != nil {
+				a.Value = slog.AnyValue(newErrorValue(err, typeNames, 0))
+			}
+		}
+		ret = append(ret, a)
+	}
+	return ret
+}
@@ -0,0 +1,42 @@
+package shandler
+
+import (
+	"context"
+	"os"
+	"time"
+)
+
+const defaultShutdownTimeout = 5 * time.Second
+
+// fatal flushes the writers, runs the shutdown hooks and exits. The hooks and
+// the flush share a single timeout; once it expires the process exits even if
+// they have not returned
+func (n *Handler) fatal() {
+	timeout := n.shutdownTimeout
+	if timeout <= 0 {
+		timeout = defaultShutdownTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = n.Flush(ctx)
+		for _, hook := range n.shutdownHooks {
+			hook(ctx)
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+
+	exit := n.exitFunc
+	if exit == nil {
+		exit = os.Exit
+	}
+	exit(n.fatalExitCode)
+}
@@ -3,15 +3,15 @@ package shandler
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"os"
-	"path/filepath"
-	"runtime"
 	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/charmbracelet/lipgloss"
@@ -31,12 +31,21 @@ func init() {
 }
 
 type Handler struct {
+	// mu is shared by the handler and every handler derived from it with
+	// WithAttrs or WithGroup so writes to the same io.Writer don't interleave
+	mu *sync.Mutex
+	// closeOnce is shared the same way so writers are only closed once
+	closeOnce *sync.Once
+
 	json        bool
+	logfmt      bool
+	syslog      *SyslogOptions
 	pid         bool
 	shortLevels bool
 
-	lineInfo      bool
-	lineInfoShort bool
+	lineInfo       bool
+	lineInfoFormat LineInfoFormat
+	callerSkip     int
 
 	out                   []io.Writer
 	err                   []io.Writer
@@ -44,11 +53,22 @@ type Handler struct {
 	textOutputFormat      string
 	groupTextOutputFormat string
 	groupRightJustify     bool
-	level                 slog.Level
+	level                 slog.Leveler
 
 	errorTag     bool
 	errorTagNuid *nuid.NUID
 
+	stackTrace bool
+	stackDepth int
+
+	richErrors bool
+	errorTypes bool
+
+	redactRules []RedactRule
+
+	pseudonymizer  *Pseudonymizer
+	pseudonymAttrs []string
+
 	color      bool
 	traceColor string
 	debugColor string
@@ -59,24 +79,64 @@ type Handler struct {
 
 	group       string
 	groupFilter []string
-	attrs       []slog.Attr
+	goas        []groupOrAttrs
+
+	// groupLevels maps group path patterns to levels. groupLeveler is the
+	// level matching the current group, resolved whenever the group changes
+	groupLevels  map[string]slog.Level
+	groupLeveler slog.Leveler
+
+	replaceAttr func(groups []string, a slog.Attr) slog.Attr
+
+	contextExtractors []func(context.Context) []slog.Attr
+	traceExtractor    TraceExtractor
+
+	duplicateKeys DuplicateKeyPolicy
+
+	dedup       bool
+	dedupWindow time.Duration
+	deduper     *deduper
+
+	sampling *SamplingOptions
+	sampler  *sampler
+
+	rateLimit      *rateLimit
+	groupRateLimit *rateLimit
+	rateLimiter    *rateLimiter
+
+	asyncSize   int
+	asyncPolicy AsyncPolicy
+	async       *asyncWriter
+
+	// jsonPrefix and textPrefix hold the attributes added with WithAttrs
+	// encoded for JSON or text output, nil unless preEncode allows it
+	jsonPrefix *jsonPrefix
+	textPrefix *textPrefix
+
+	fatalExit       bool
+	fatalExitCode   int
+	shutdownHooks   []func(context.Context)
+	shutdownTimeout time.Duration
+	exitFunc        func(int)
 }
 
 type HandlerOption func(*Handler)
 
 func NewHandler(opts ...HandlerOption) *Handler {
 	nh := &Handler{
+		mu:                    new(sync.Mutex),
+		closeOnce:             new(sync.Once),
 		pid:                   false,
-		attrs:                 []slog.Attr{},
 		out:                   []io.Writer{os.Stdout},
 		err:                   []io.Writer{os.Stderr},
 		shortLevels:           false,
 		lineInfo:              false,
-		lineInfoShort:         true,
+		lineInfoFormat:        LineInfoShort,
 		timeFormat:            time.TimeOnly,
 		textOutputFormat:      "[%s] %s - %s\n",
 		groupTextOutputFormat: "%s | %s",
 		groupFilter:           []string{},
+		groupLevels:           map[string]slog.Level{},
 		level:                 slog.LevelInfo,
 		errorTag:              false,
 		color:                 false,
@@ -92,122 +152,268 @@ func NewHandler(opts ...HandlerOption) *Handler {
 		opt(nh)
 	}
 
+	nh.groupLeveler = groupLevel(nh.groupLevels, nh.group)
+
 	if nh.errorTag {
 		nh.errorTagNuid = nuid.New()
 	}
 
+	if nh.dedup {
+		nh.deduper = newDeduper(nh, nh.dedupWindow)
+	}
+
+	if nh.sampling != nil {
+		nh.sampler = newSampler(nh, *nh.sampling)
+	}
+
+	if nh.rateLimit != nil || nh.groupRateLimit != nil {
+		nh.rateLimiter = newRateLimiter(nh, nh.rateLimit, nh.groupRateLimit)
+	}
+
+	if nh.asyncSize > 0 {
+		nh.async = newAsyncWriter(nh.mu, nh.asyncSize, nh.asyncPolicy)
+	}
+
+	nh.initPrefix()
+
 	return nh
 }
 
 // NewHandlerFromConfig will allow you to pass in the settings to
 // slog.New(NewHandlerFromConfig). You will need to inclused the io.Writers
 // in the NewHandlerFromConfig call as they are not serializable.
-// Use ToConfig to get the config of your original Handler
-func NewHandlerFromConfig(config []byte, stdout, stderr []io.Writer) (*Handler, error) {
-	nh := new(Handler)
+// Use ToConfig to get the config of your original Handler.
+//
+// Settings that are not serializable, such as the Pseudonymizer of
+// WithPseudonymization, are passed as opts. A config with pseudonymization
+// patterns is rejected when no Pseudonymizer is given, rather than logging
+// the values in the clear
+func NewHandlerFromConfig(config []byte, stdout, stderr []io.Writer, opts ...HandlerOption) (*Handler, error) {
+	nh := &Handler{mu: new(sync.Mutex), closeOnce: new(sync.Once)}
 	if err := json.Unmarshal(config, nh); err != nil {
 		return nil, err
 	}
 
 	nh.out = stdout
 	nh.err = stderr
+
+	for _, opt := range opts {
+		opt(nh)
+	}
+
+	if len(nh.pseudonymAttrs) != 0 && nh.pseudonymizer == nil {
+		return nil, errors.New("config has pseudonymization patterns but no Pseudonymizer was given")
+	}
+
+	if nh.dedup {
+		nh.deduper = newDeduper(nh, nh.dedupWindow)
+	}
+
+	if nh.sampling != nil {
+		nh.sampler = newSampler(nh, *nh.sampling)
+	}
+
+	if nh.rateLimit != nil || nh.groupRateLimit != nil {
+		nh.rateLimiter = newRateLimiter(nh, nh.rateLimit, nh.groupRateLimit)
+	}
+
+	if nh.asyncSize > 0 {
+		nh.async = newAsyncWriter(nh.mu, nh.asyncSize, nh.asyncPolicy)
+	}
+
+	nh.initPrefix()
 	return nh, nil
 }
 
+// preEncode reports whether the attributes added with WithAttrs can be
+// encoded once, when the handler is derived, rather than for every record.
+// That is the case for JSON and text output when no option rewrites
+// attributes
+func (n *Handler) preEncode() bool {
+	return !n.logfmt && n.syslog == nil &&
+		n.replaceAttr == nil && !n.richErrors && len(n.redactRules) == 0 &&
+		(n.pseudonymizer == nil || len(n.pseudonymAttrs) == 0) &&
+		(!n.json || n.duplicateKeys == DuplicateKeysAllow)
+}
+
+// initPrefix sets up the prefix of the output mode when preEncode allows it
+// and encodes the groups and attributes the handler already has
+func (n *Handler) initPrefix() {
+	if !n.preEncode() {
+		return
+	}
+	if n.json {
+		n.jsonPrefix = newJSONPrefix()
+	} else {
+		n.textPrefix = &textPrefix{}
+	}
+
+	var groups []groupOrAttrs
+	for _, goa := range n.goas {
+		if n.jsonPrefix != nil {
+			n.jsonPrefix = n.jsonPrefix.with(goa)
+		} else {
+			n.textPrefix = n.textPrefix.with(goa, groupPath(groups))
+		}
+		groups = append(groups, goa)
+	}
+}
+
 func (n *Handler) Enabled(_ context.Context, level slog.Level) bool {
-	return level >= n.level
+	// fatal records always reach Handle so the process exits, even if they
+	// are not written
+	if n.fatalExit && level >= LevelFatal {
+		return true
+	}
+	return n.enabled(level)
+}
+
+func (n *Handler) enabled(level slog.Level) bool {
+	if n.filtered() {
+		return false
+	}
+	if n.groupLeveler != nil {
+		return level >= n.groupLeveler.Level()
+	}
+	return level >= n.level.Level()
+}
+
+// filtered reports whether the current group, or one of its parents, is in
+// the WithGroupFilter list
+func (n *Handler) filtered() bool {
+	if n.group == "" {
+		return false
+	}
+	return slices.ContainsFunc(n.groupFilter, func(f string) bool {
+		return groupMatch(f, n.group)
+	})
 }
 
 func (n *Handler) Handle(ctx context.Context, record slog.Record) error {
-	if slices.Contains(n.groupFilter, n.group) {
+	var err error
+	if n.allow(ctx, record) {
+		err = n.handle(ctx, record)
+	}
+	if n.fatalExit && record.Level >= LevelFatal {
+		n.fatal()
+	}
+	return err
+}
+
+// allow applies WithDedup, WithSampling and then the rate limits. FATAL
+// records are never deduplicated, sampled or rate limited
+func (n *Handler) allow(ctx context.Context, record slog.Record) bool {
+	if n.deduper != nil && record.Level < LevelFatal && !n.deduper.allow(ctx, n, record) {
+		return false
+	}
+	if n.sampler != nil && record.Level < LevelFatal && !n.sampler.sample(ctx, record.Level, record.Message) {
+		return false
+	}
+	if n.rateLimiter != nil && record.Level < LevelFatal {
+		return n.rateLimiter.allow(ctx, n.group)
+	}
+	return true
+}
+
+func (n *Handler) handle(ctx context.Context, record slog.Record) error {
+	if n.filtered() {
+		return nil
+	}
+	if n.fatalExit && record.Level >= LevelFatal && !n.enabled(record.Level) {
 		return nil
 	}
 
-	attrs := n.attrs
-	record.Attrs(func(attr slog.Attr) bool {
-		attrs = append(attrs, attr)
-		return true
-	})
+	// with a prefix only the record attributes are collected, the ones added
+	// with WithAttrs are already encoded and attrs holds the top level
+	// attributes written after them
+	var attrs, recordAttrs []slog.Attr
+	var recordArr [8]slog.Attr
+	if n.jsonPrefix != nil || n.textPrefix != nil {
+		recordAttrs = appendRecordAttrs(recordArr[:0], record)
+	} else {
+		attrs = n.collectAttrs(record)
+	}
+	attrs = append(attrs, n.contextAttrs(ctx)...)
+	attrs = append(attrs, n.traceAttrs(ctx)...)
 
-	// This was adapted from stdlib record.go:219
 	if n.lineInfo {
-		fs := runtime.CallersFrames([]uintptr{record.PC})
-		f, _ := fs.Next()
-
-		var logLine string
-		if n.lineInfoShort {
-			fileBase := filepath.Base(f.File)
-			logLine = fmt.Sprintf("%s:%d", fileBase, f.Line)
-		} else {
-			logLine = fmt.Sprintf("%s [%s:%d]", f.Function, f.File, f.Line)
+		if a, ok := n.sourceAttr(record.PC); ok {
+			attrs = append(attrs, a)
 		}
+	}
 
-		if logLine != "" {
-			attrs = append(attrs, slog.String("slog_info", logLine))
-		} else {
-			attrs = append(attrs, slog.String("slog_info", "unknown"))
-		}
+	if n.errorTag && n.errorTagNuid != nil && record.Level >= slog.LevelError {
+		// nuid.NUID is not safe for concurrent use
+		n.mu.Lock()
+		eTag := n.errorTagNuid.Next()
+		n.mu.Unlock()
+		attrs = append(attrs, slog.String("error_id", eTag))
 	}
 
-	textFormat := func() string {
-		if n.group != "" && !n.groupRightJustify {
-			return fmt.Sprintf(n.groupTextOutputFormat, n.group, n.textOutputFormat)
-		}
-		return n.textOutputFormat
+	if n.stackTrace && record.Level >= slog.LevelError {
+		attrs = append(attrs, slog.Any(StackKey, n.stack(record.PC)))
 	}
 
-	outLoc := func() []io.Writer {
-		if record.Level >= slog.LevelError {
-			return n.err
-		}
-		return n.out
+	if n.replaceAttr != nil {
+		attrs = replaceAttrs(n.replaceAttr, nil, attrs)
+	}
+
+	if n.richErrors {
+		attrs = richErrors(attrs, n.errorTypes)
+	}
+
+	if n.pseudonymizer != nil && len(n.pseudonymAttrs) != 0 {
+		attrs = pseudonymizeAttrs(n.pseudonymizer, n.pseudonymAttrs, "", attrs)
 	}
 
-	level := func() string {
-		if n.shortLevels {
-			switch record.Level {
+	if len(n.redactRules) != 0 {
+		attrs = redactAttrs(n.redactRules, "", attrs)
+	}
+
+	levelKey, levelValue, levelOk := n.replaceBuiltin(slog.Any(slog.LevelKey, record.Level), "level")
+	var plainLevel, recordLevel string
+	if lvl, ok := levelValue.Any().(slog.Level); ok && levelOk {
+		plainLevel = n.levelName(lvl)
+		recordLevel = plainLevel
+		if n.color {
+			switch lvl {
 			case LevelTrace:
-				return "TRC"
+				recordLevel = lipgloss.NewStyle().Foreground(lipgloss.Color(n.traceColor)).Render(plainLevel)
 			case slog.LevelDebug:
-				return "DBG"
+				recordLevel = lipgloss.NewStyle().Foreground(lipgloss.Color(n.debugColor)).Render(plainLevel)
 			case slog.LevelInfo:
-				return "INF"
+				recordLevel = lipgloss.NewStyle().Foreground(lipgloss.Color(n.infoColor)).Render(plainLevel)
 			case slog.LevelWarn:
-				return "WRN"
+				recordLevel = lipgloss.NewStyle().Foreground(lipgloss.Color(n.warnColor)).Render(plainLevel)
 			case slog.LevelError:
-				return "ERR"
-			case LevelFatal:
-				return "FTL"
-			}
-		} else {
-			switch record.Level {
-			case LevelTrace:
-				return "TRACE"
+				recordLevel = lipgloss.NewStyle().Foreground(lipgloss.Color(n.errorColor)).Render(plainLevel)
 			case LevelFatal:
-				return "FATAL"
+				recordLevel = lipgloss.NewStyle().Foreground(lipgloss.Color(n.fatalColor)).Render(plainLevel)
 			}
 		}
-		return record.Level.String()
+	} else if levelOk {
+		plainLevel = levelValue.String()
+		recordLevel = plainLevel
 	}
 
-	var recordLevel string
-	if n.color {
-		switch record.Level {
-		case LevelTrace:
-			recordLevel = lipgloss.NewStyle().Foreground(lipgloss.Color(n.traceColor)).Render(level())
-		case slog.LevelDebug:
-			recordLevel = lipgloss.NewStyle().Foreground(lipgloss.Color(n.debugColor)).Render(level())
-		case slog.LevelInfo:
-			recordLevel = lipgloss.NewStyle().Foreground(lipgloss.Color(n.infoColor)).Render(level())
-		case slog.LevelWarn:
-			recordLevel = lipgloss.NewStyle().Foreground(lipgloss.Color(n.warnColor)).Render(level())
-		case slog.LevelError:
-			recordLevel = lipgloss.NewStyle().Foreground(lipgloss.Color(n.errorColor)).Render(level())
-		case LevelFatal:
-			recordLevel = lipgloss.NewStyle().Foreground(lipgloss.Color(n.fatalColor)).Render(level())
+	var timeKey string
+	var timeValue slog.Value
+	var timeOk bool
+	if !record.Time.IsZero() {
+		timeKey, timeValue, timeOk = n.replaceBuiltin(slog.Time(slog.TimeKey, record.Time), "time")
+	}
+
+	defaultMessageKey := "message"
+	if n.logfmt {
+		defaultMessageKey = "msg"
+	}
+	messageKey, messageValue, messageOk := n.replaceBuiltin(slog.String(slog.MessageKey, record.Message), defaultMessageKey)
+	var message string
+	if messageOk {
+		message = messageValue.String()
+		if len(n.redactRules) != 0 {
+			message = redactMessage(n.redactRules, message)
 		}
-	} else {
-		recordLevel = level()
 	}
 
 	var pid string
@@ -215,62 +421,264 @@ func (n *Handler) Handle(ctx context.Context, record slog.Record) error {
 		pid = strconv.Itoa(os.Getpid())
 	}
 
-	if n.errorTag && n.errorTagNuid != nil && record.Level >= slog.LevelError {
-		eTag := n.errorTagNuid.Next()
-		attrs = append(attrs, slog.String("error_id", eTag))
+	if n.syslog != nil {
+		// the header fields have fixed positions, so only replaced values that
+		// are still a slog.Level and a time.Time are used
+		syslogLevel := record.Level
+		if lvl, ok := levelValue.Any().(slog.Level); ok && levelOk {
+			syslogLevel = lvl
+		}
+		var syslogTime time.Time
+		if timeOk && timeValue.Kind() == slog.KindTime {
+			syslogTime = timeValue.Time()
+		}
+
+		bufp := getBuffer()
+		defer putBuffer(bufp)
+
+		*bufp = appendSyslog(*bufp, n.syslog, syslogLevel, syslogTime, message, pid, attrs)
+		return n.write(n.output(record.Level), *bufp)
+	}
+
+	// JSON appends the time to its buffer, the other modes need a string
+	var recordTime string
+	if timeOk && (n.logfmt || !n.json) {
+		recordTime = string(n.appendTime(nil, timeValue))
+	}
+
+	if n.logfmt {
+		sb := strings.Builder{}
+		if levelOk {
+			appendLogfmt(&sb, levelKey, strings.ToLower(plainLevel))
+		}
+		if recordTime != "" {
+			appendLogfmt(&sb, timeKey, recordTime)
+		}
+		if messageOk {
+			appendLogfmt(&sb, messageKey, message)
+		}
+		if pid != "" {
+			appendLogfmt(&sb, "pid", pid)
+		}
+		logfmtAttrs(&sb, "", attrs)
+		return printer(n, n.output(record.Level), sb.String())
 	}
 
 	if !n.json {
-		output := textFormat()
+		output := n.textFormat()
 		attsString := strings.Builder{}
 
-		if len(attrs) != 0 {
+		var stack string
+		if n.stackTrace {
+			var s Stack
+			attrs, s = splitStack(attrs)
+			stack = s.block()
+		}
+
+		var pairs []string
+		if n.textPrefix != nil {
+			pairs = n.textPrefix.append(n.group, recordAttrs)
+		}
+		pairs = textAttrs("", attrs, pairs)
+
+		if len(pairs) != 0 {
 			output = strings.TrimSpace(output)
-			for i, a := range attrs {
-				attsString.WriteString(a.String())
-				if i < len(attrs)-1 {
-					attsString.WriteString(" ")
-				}
-			}
+			attsString.WriteString(strings.Join(pairs, " "))
 			attsString.WriteString("\n")
 			output = output + " " + attsString.String()
 		}
 
 		if n.groupRightJustify {
-			printerrj(outLoc(), n.group, pid, output, recordLevel, record.Time.Format(n.timeFormat), record.Message)
-		} else {
-			printerf(outLoc(), pid, output, recordLevel, record.Time.Format(n.timeFormat), record.Message)
+			return printerrj(n, n.output(record.Level), n.group, pid, stack, output, recordLevel, recordTime, message)
+		}
+		return printerf(n, n.output(record.Level), pid, stack, output, recordLevel, recordTime, message)
+	}
+
+	bufp := getBuffer()
+	defer putBuffer(bufp)
+
+	buf := append(*bufp, '{')
+	if levelOk {
+		buf = appendJSONKey(buf, levelKey)
+		buf = appendJSONString(buf, plainLevel)
+	}
+	if timeOk {
+		// formatted on the stack, a time is not escaped in place
+		var timeBuf [64]byte
+		if t := n.appendTime(timeBuf[:0], timeValue); len(t) != 0 {
+			buf = appendJSONKey(buf, timeKey)
+			buf = appendJSONString(buf, string(t))
+		}
+	}
+	if messageOk {
+		buf = appendJSONKey(buf, messageKey)
+		buf = appendJSONString(buf, message)
+	}
+	if n.group != "" {
+		buf = appendJSONKey(buf, "group")
+		buf = appendJSONString(buf, n.group)
+	}
+	if n.jsonPrefix != nil {
+		buf = appendJSONTree(buf, n.jsonPrefix, recordAttrs, attrs)
+	} else if len(attrs) != 0 {
+		buf = appendJSONKey(buf, "attrs")
+		buf = append(buf, '{')
+		buf = appendJSONAttrs(buf, dedupeAttrs(n.duplicateKeys, attrs))
+		buf = append(buf, '}')
+	}
+	if pid != "" {
+		buf = appendJSONKey(buf, "pid")
+		buf = appendJSONString(buf, pid)
+	}
+	buf = append(buf, '}', '\n')
+	*bufp = buf
+
+	return n.write(n.output(record.Level), buf)
+}
+
+// textFormat returns the text output format, prefixed with the group unless
+// it is right justified
+func (n *Handler) textFormat() string {
+	if n.group != "" && !n.groupRightJustify {
+		return fmt.Sprintf(n.groupTextOutputFormat, n.group, n.textOutputFormat)
+	}
+	return n.textOutputFormat
+}
+
+// output returns the error output for ERROR records and above and the
+// standard output for the others
+func (n *Handler) output(l slog.Level) []io.Writer {
+	if l >= slog.LevelError {
+		return n.err
+	}
+	return n.out
+}
+
+// levelName returns the name a level is written with
+func (n *Handler) levelName(l slog.Level) string {
+	if n.shortLevels {
+		switch l {
+		case LevelTrace:
+			return "TRC"
+		case slog.LevelDebug:
+			return "DBG"
+		case slog.LevelInfo:
+			return "INF"
+		case slog.LevelWarn:
+			return "WRN"
+		case slog.LevelError:
+			return "ERR"
+		case LevelFatal:
+			return "FTL"
 		}
 	} else {
-		a_map := make(map[string]any)
-		for _, a := range attrs {
-			a_map[a.Key] = a.Value.Any()
+		switch l {
+		case LevelTrace:
+			return "TRACE"
+		case LevelFatal:
+			return "FATAL"
 		}
+	}
+	return l.String()
+}
+
+// appendTime writes the time of a record, in the time format unless
+// WithReplaceAttr replaced it with another kind of value
+func (n *Handler) appendTime(buf []byte, v slog.Value) []byte {
+	if v.Kind() == slog.KindTime {
+		return v.Time().AppendFormat(buf, n.timeFormat)
+	}
+	return append(buf, v.String()...)
+}
+
+// Flush ends the current WithDedup run, logs the WithSampling summary of the
+// current tick and the records dropped by the rate limits since the last
+// report, waits until every
+// record logged before it was called has been written when WithAsync is set,
+// then calls Flush() error and Sync() error on every writer that implements
+// them. Errors are joined
+func (n *Handler) Flush(ctx context.Context) error {
+	if n.deduper != nil {
+		n.deduper.flush()
+	}
+	if n.sampler != nil {
+		n.sampler.flush(ctx)
+	}
+	if n.rateLimiter != nil {
+		n.rateLimiter.flush(ctx)
+	}
+	if n.async != nil {
+		if err := n.async.flush(ctx); err != nil {
+			return err
+		}
+	}
+	return n.flushWriters()
+}
 
-		l := jsonLog{
-			Level:   level(),
-			Time:    record.Time.Format(n.timeFormat),
-			Message: record.Message,
-			Group:   n.group,
-			Attrs:   a_map,
-			Pid:     pid,
+// Close writes any queued records, stops the background writer started by
+// WithAsync, flushes the writers and closes the ones implementing io.Closer.
+// os.Stdout and os.Stderr are never closed. Writers shared by the standard
+// and error output are only closed once.
+//
+// Close applies to the handler and every handler derived from it, only the
+// first call has an effect
+func (n *Handler) Close() error {
+	var err error
+	n.closeOnce.Do(func() {
+		if n.deduper != nil {
+			n.deduper.flush()
+		}
+		if n.sampler != nil {
+			n.sampler.flush(context.Background())
+		}
+		if n.rateLimiter != nil {
+			n.rateLimiter.flush(context.Background())
+		}
+		if n.async != nil {
+			n.async.close()
 		}
+		err = errors.Join(n.flushWriters(), n.closeWriters())
+	})
+	return err
+}
 
-		l_raw, _ := json.Marshal(l)
-		printer(outLoc(), string(l_raw))
+// Dropped returns the number of records dropped because the WithAsync queue
+// was full
+func (n *Handler) Dropped() uint64 {
+	if n.async == nil {
+		return 0
 	}
-	return nil
+	return n.async.dropped.Load()
 }
 
 func (n *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
-	newHandler := *n
-	newHandler.attrs = append(newHandler.attrs, attrs...)
-	return &newHandler
+	attrs = normalizeAttrs(attrs)
+	if len(attrs) == 0 {
+		return n
+	}
+	return n.withGroupOrAttrs(groupOrAttrs{attrs: attrs})
 }
 
 func (n *Handler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return n
+	}
+	return n.withGroupOrAttrs(groupOrAttrs{group: name})
+}
+
+func (n *Handler) withGroupOrAttrs(goa groupOrAttrs) *Handler {
 	newHandler := *n
-	newHandler.group = name
+	newHandler.goas = append(slices.Clip(n.goas), goa)
+	if n.jsonPrefix != nil {
+		newHandler.jsonPrefix = n.jsonPrefix.with(goa)
+	}
+	if n.textPrefix != nil {
+		newHandler.textPrefix = n.textPrefix.with(goa, n.group)
+	}
+	if goa.group != "" {
+		newHandler.group = groupPath(newHandler.goas)
+		newHandler.groupLeveler = groupLevel(n.groupLevels, newHandler.group)
+	}
 	return &newHandler
 }
 
@@ -283,24 +691,41 @@ func ToConfig(h slog.Handler) ([]byte, error) {
 }
 
 type attrValue struct {
-	Kind  slog.Kind `json:"kind"`
-	Value string    `json:"value"`
+	Key   string      `json:"key"`
+	Kind  slog.Kind   `json:"kind"`
+	Value string      `json:"value,omitempty"`
+	Group []attrValue `json:"group,omitempty"`
+}
+
+type groupOrAttrsValue struct {
+	Group string      `json:"group,omitempty"`
+	Attrs []attrValue `json:"attrs,omitempty"`
 }
 
 func (n Handler) MarshalJSON() ([]byte, error) {
-	a := map[string]attrValue{}
-	for _, aT := range n.attrs {
-		a[aT.Key] = attrValue{Kind: aT.Value.Kind(), Value: aT.Value.String()}
+	goas := make([]groupOrAttrsValue, 0, len(n.goas))
+	for _, goa := range n.goas {
+		goas = append(goas, groupOrAttrsValue{Group: goa.group, Attrs: toAttrValues(goa.attrs)})
 	}
 
 	return json.Marshal(map[string]any{
 		"json":                     n.json,
+		"logfmt":                   n.logfmt,
+		"syslog":                   n.syslog,
 		"short_levels":             n.shortLevels,
 		"line_info":                n.lineInfo,
+		"line_info_format":         n.lineInfoFormat,
+		"stack_trace":              n.stackTrace,
+		"stack_depth":              n.stackDepth,
+		"rich_errors":              n.richErrors,
+		"error_types":              n.errorTypes,
+		"redact_rules":             toRedactRuleValues(n.redactRules),
+		"pseudonym_attrs":          n.pseudonymAttrs,
+		"caller_skip":              n.callerSkip,
 		"time_format":              n.timeFormat,
 		"text_output_format":       n.textOutputFormat,
 		"group_text_output_format": n.groupTextOutputFormat,
-		"level":                    n.level,
+		"level":                    n.level.Level(),
 		"color":                    n.color,
 		"trace_color":              n.traceColor,
 		"debug_color":              n.debugColor,
@@ -308,31 +733,63 @@ func (n Handler) MarshalJSON() ([]byte, error) {
 		"warn_color":               n.warnColor,
 		"error_color":              n.errorColor,
 		"fatal_color":              n.fatalColor,
-		"group":                    n.group,
 		"group_filter":             n.groupFilter,
-		"attrs":                    a,
+		"group_levels":             n.groupLevels,
+		"groups_and_attrs":         goas,
+		"duplicate_keys":           n.duplicateKeys,
+		"dedup":                    n.dedup,
+		"dedup_window":             n.dedupWindow,
+		"sampling":                 n.sampling,
+		"rate_limit":               n.rateLimit,
+		"group_rate_limit":         n.groupRateLimit,
+		"async_size":               n.asyncSize,
+		"async_policy":             n.asyncPolicy,
+		"fatal_exit":               n.fatalExit,
+		"fatal_exit_code":          n.fatalExitCode,
+		"shutdown_timeout":         n.shutdownTimeout,
 	})
 }
 
 func (n *Handler) UnmarshalJSON(data []byte) error {
 	temp := struct {
-		Json                  bool                 `json:"json"`
-		ShortLevels           bool                 `json:"short_levels"`
-		LineInfo              bool                 `json:"line_info"`
-		TimeFormat            string               `json:"time_format"`
-		TextOutputFormat      string               `json:"text_output_format"`
-		GroupTextOutputFormat string               `json:"group_text_output_format"`
-		Level                 slog.Level           `json:"level"`
-		Color                 bool                 `json:"color"`
-		TraceColor            string               `json:"trace_color"`
-		DebugColor            string               `json:"debug_color"`
-		InfoColor             string               `json:"info_color"`
-		WarnColor             string               `json:"warn_color"`
-		ErrorColor            string               `json:"error_color"`
-		FatalColor            string               `json:"fatal_color"`
-		Group                 string               `json:"group"`
-		GroupFilter           []string             `json:"group_filter"`
-		Attrs                 map[string]attrValue `json:"attrs"`
+		Json                  bool                  `json:"json"`
+		Logfmt                bool                  `json:"logfmt"`
+		Syslog                *SyslogOptions        `json:"syslog"`
+		ShortLevels           bool                  `json:"short_levels"`
+		LineInfo              bool                  `json:"line_info"`
+		LineInfoFormat        LineInfoFormat        `json:"line_info_format"`
+		StackTrace            bool                  `json:"stack_trace"`
+		StackDepth            int                   `json:"stack_depth"`
+		RichErrors            bool                  `json:"rich_errors"`
+		ErrorTypes            bool                  `json:"error_types"`
+		RedactRules           []redactRuleValue     `json:"redact_rules"`
+		PseudonymAttrs        []string              `json:"pseudonym_attrs"`
+		CallerSkip            int                   `json:"caller_skip"`
+		TimeFormat            string                `json:"time_format"`
+		TextOutputFormat      string                `json:"text_output_format"`
+		GroupTextOutputFormat string                `json:"group_text_output_format"`
+		Level                 slog.Level            `json:"level"`
+		Color                 bool                  `json:"color"`
+		TraceColor            string                `json:"trace_color"`
+		DebugColor            string                `json:"debug_color"`
+		InfoColor             string                `json:"info_color"`
+		WarnColor             string                `json:"warn_color"`
+		ErrorColor            string                `json:"error_color"`
+		FatalColor            string                `json:"fatal_color"`
+		GroupFilter           []string              `json:"group_filter"`
+		GroupLevels           map[string]slog.Level `json:"group_levels"`
+		GroupsAndAttrs        []groupOrAttrsValue   `json:"groups_and_attrs"`
+		DuplicateKeys         DuplicateKeyPolicy    `json:"duplicate_keys"`
+		Dedup                 bool                  `json:"dedup"`
+		DedupWindow           time.Duration         `json:"dedup_window"`
+		Sampling              *SamplingOptions      `json:"sampling"`
+		RateLimit             *rateLimit            `json:"rate_limit"`
+		GroupRateLimit        *rateLimit            `json:"group_rate_limit"`
+		AsyncSize             int                   `json:"async_size"`
+		AsyncPolicy           AsyncPolicy           `json:"async_policy"`
+		FatalExit             bool                  `json:"fatal_exit"`
+		FatalExitCode         int                   `json:"fatal_exit_code"`
+		ShutdownTimeout       time.Duration         `json:"shutdown_timeout"`
 	}{}
 
 	if err := json.Unmarshal(data, &temp); err != nil {
@@ -340,7 +797,17 @@ func (n *Handler) UnmarshalJSON(data []byte) error {
 	}
 
 	n.json = temp.Json
+	n.logfmt = temp.Logfmt
+	n.syslog = temp.Syslog
 	n.shortLevels = temp.ShortLevels
+	n.lineInfo = temp.LineInfo
+	n.lineInfoFormat = temp.LineInfoFormat
+	n.stackTrace = temp.StackTrace
+	n.stackDepth = temp.StackDepth
+	n.richErrors = temp.RichErrors
+	n.errorTypes = temp.ErrorTypes
+	n.pseudonymAttrs = temp.PseudonymAttrs
+	n.callerSkip = temp.CallerSkip
 	n.timeFormat = temp.TimeFormat
 	n.textOutputFormat = temp.TextOutputFormat
 	n.groupTextOutputFormat = temp.GroupTextOutputFormat
@@ -352,54 +819,102 @@ func (n *Handler) UnmarshalJSON(data []byte) error {
 	n.warnColor = temp.WarnColor
 	n.errorColor = temp.ErrorColor
 	n.fatalColor = temp.FatalColor
-	n.group = temp.Group
 	n.groupFilter = temp.GroupFilter
+	n.groupLevels = temp.GroupLevels
+	n.duplicateKeys = temp.DuplicateKeys
+	n.dedup = temp.Dedup
+	n.dedupWindow = temp.DedupWindow
+	n.sampling = temp.Sampling
+	n.rateLimit = temp.RateLimit
+	n.groupRateLimit = temp.GroupRateLimit
+	n.asyncSize = temp.AsyncSize
+	n.asyncPolicy = temp.AsyncPolicy
+	n.fatalExit = temp.FatalExit
+	n.fatalExitCode = temp.FatalExitCode
+	n.shutdownTimeout = temp.ShutdownTimeout
+
+	rules, err := fromRedactRuleValues(temp.RedactRules)
+	if err != nil {
+		return err
+	}
+	if len(rules) != 0 {
+		n.redactRules = rules
+	}
+
+	for _, goa := range temp.GroupsAndAttrs {
+		attrs, err := fromAttrValues(goa.Attrs)
+		if err != nil {
+			return err
+		}
+		n.goas = append(n.goas, groupOrAttrs{group: goa.Group, attrs: attrs})
+	}
+	n.group = groupPath(n.goas)
+	n.groupLeveler = groupLevel(n.groupLevels, n.group)
 
-	for k, v := range temp.Attrs {
+	return nil
+}
+
+func toAttrValues(attrs []slog.Attr) []attrValue {
+	ret := make([]attrValue, 0, len(attrs))
+	for _, a := range attrs {
+		v := attrValue{Key: a.Key, Kind: a.Value.Kind()}
+		switch a.Value.Kind() {
+		case slog.KindGroup:
+			v.Group = toAttrValues(a.Value.Group())
+		case slog.KindTime:
+			v.Value = a.Value.Time().Format(time.RFC3339Nano)
+		default:
+			v.Value = a.Value.String()
+		}
+		ret = append(ret, v)
+	}
+	return ret
+}
+
+func fromAttrValues(values []attrValue) ([]slog.Attr, error) {
+	ret := make([]slog.Attr, 0, len(values))
+	for _, v := range values {
 		switch v.Kind {
-		case slog.KindAny:
-			n.attrs = append(n.attrs, slog.Any(k, v.Value))
+		case slog.KindAny, slog.KindLogValuer:
+			ret = append(ret, slog.Any(v.Key, v.Value))
 		case slog.KindBool:
-			n.attrs = append(n.attrs, slog.Bool(k, v.Value == "true"))
+			ret = append(ret, slog.Bool(v.Key, v.Value == "true"))
 		case slog.KindDuration:
 			d, _ := time.ParseDuration(v.Value)
-			n.attrs = append(n.attrs, slog.Duration(k, d))
+			ret = append(ret, slog.Duration(v.Key, d))
 		case slog.KindFloat64:
 			num, err := strconv.ParseFloat(v.Value, 64)
 			if err != nil {
-				return err
+				return nil, err
 			}
-			n.attrs = append(n.attrs, slog.Float64(k, num))
+			ret = append(ret, slog.Float64(v.Key, num))
 		case slog.KindInt64:
 			num, err := strconv.ParseInt(v.Value, 10, 64)
 			if err != nil {
-				return err
+				return nil, err
 			}
-			n.attrs = append(n.attrs, slog.Int64(k, num))
+			ret = append(ret, slog.Int64(v.Key, num))
 		case slog.KindString:
-			n.attrs = append(n.attrs, slog.String(k, v.Value))
+			ret = append(ret, slog.String(v.Key, v.Value))
 		case slog.KindTime:
-			t, err := time.Parse(n.timeFormat, v.Value)
+			t, err := time.Parse(time.RFC3339Nano, v.Value)
 			if err != nil {
-				return err
+				return nil, err
 			}
-			n.attrs = append(n.attrs, slog.Time(k, t))
+			ret = append(ret, slog.Time(v.Key, t))
 		case slog.KindUint64:
 			num, err := strconv.ParseUint(v.Value, 10, 64)
 			if err != nil {
-				return err
+				return nil, err
+			}
+			ret = append(ret, slog.Uint64(v.Key, num))
+		case slog.KindGroup:
+			group, err := fromAttrValues(v.Group)
+			if err != nil {
+				return nil, err
 			}
-			n.attrs = append(n.attrs, slog.Uint64(k, num))
+			ret = append(ret, slog.Attr{Key: v.Key, Value: slog.GroupValue(group...)})
 		}
 	}
-	return nil
-}
-
-type jsonLog struct {
-	Level   string         `json:"level"`
-	Time    string         `json:"time"`
-	Message string         `json:"message"`
-	Group   string         `json:"group,omitempty"`
-	Attrs   map[string]any `json:"attrs,omitempty"`
-	Pid     string         `json:"pid,omitempty"`
+	return ret, nil
 }
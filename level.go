@@ -1,8 +1,41 @@
 package shandler
 
-import "log/slog"
+import (
+	"log/slog"
+	"path"
+	"strings"
+)
 
 const (
 	LevelTrace slog.Level = slog.LevelDebug - 2
 	LevelFatal slog.Level = slog.LevelError + 2
 )
+
+// groupLevel returns the level configured for the dotted group path g, or
+// nil if no pattern matches. A pattern matches when it equals g, when it is a
+// parent of g ("db" matches "db.pool") or when it matches g using path.Match
+// rules ("http.*", "*"). The longest matching pattern wins.
+func groupLevel(levels map[string]slog.Level, g string) slog.Leveler {
+	var (
+		best  string
+		level slog.Leveler
+	)
+	for pattern, l := range levels {
+		if !groupMatch(pattern, g) {
+			continue
+		}
+		if level == nil || len(pattern) > len(best) || (len(pattern) == len(best) && pattern < best) {
+			best = pattern
+			level = l
+		}
+	}
+	return level
+}
+
+func groupMatch(pattern, g string) bool {
+	if pattern == g || strings.HasPrefix(g, pattern+".") {
+		return true
+	}
+	ok, _ := path.Match(pattern, g)
+	return ok
+}
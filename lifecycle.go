@@ -0,0 +1,76 @@
+package shandler
+
+import (
+	"errors"
+	"io"
+	"os"
+	"reflect"
+	"slices"
+)
+
+type flusher interface {
+	Flush() error
+}
+
+type syncer interface {
+	Sync() error
+}
+
+// writers returns the unique writers of the handler. The process standard
+// streams are left out, they are never closed and Sync fails on pipes
+func (n *Handler) writers() []io.Writer {
+	var ret []io.Writer
+	for _, w := range slices.Concat(n.out, n.err) {
+		if w == nil || w == io.Writer(os.Stdout) || w == io.Writer(os.Stderr) {
+			continue
+		}
+		// writers with an uncomparable dynamic type can't be deduplicated
+		if !reflect.TypeOf(w).Comparable() {
+			ret = append(ret, w)
+			continue
+		}
+		if !slices.ContainsFunc(ret, func(o io.Writer) bool {
+			return reflect.TypeOf(o).Comparable() && o == w
+		}) {
+			ret = append(ret, w)
+		}
+	}
+	return ret
+}
+
+// flushWriters calls Flush and Sync on every writer that implements them
+func (n *Handler) flushWriters() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	var errs []error
+	for _, w := range n.writers() {
+		if f, ok := w.(flusher); ok {
+			if err := f.Flush(); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		if s, ok := w.(syncer); ok {
+			if err := s.Sync(); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// closeWriters calls Close on every writer that implements io.Closer
+func (n *Handler) closeWriters() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	var errs []error
+	for _, w := range n.writers() {
+		if c, ok := w.(io.Closer); ok {
+			if err := c.Close(); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
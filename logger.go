@@ -0,0 +1,73 @@
+package shandler
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// Logger wraps a *slog.Logger with methods for the LevelTrace and LevelFatal
+// levels. Records keep the caller of the Logger method as their source, so
+// WithLineInfo points to your code.
+//
+// The Fatal methods only log at LevelFatal; use WithFatalExit on the Handler
+// to terminate the process.
+type Logger struct {
+	*slog.Logger
+}
+
+// NewLogger returns a Logger writing to h
+func NewLogger(h slog.Handler) *Logger {
+	return &Logger{Logger: slog.New(h)}
+}
+
+// With returns a Logger that includes the given attributes in each output
+func (l *Logger) With(args ...any) *Logger {
+	return &Logger{Logger: l.Logger.With(args...)}
+}
+
+// WithGroup returns a Logger that starts a group
+func (l *Logger) WithGroup(name string) *Logger {
+	return &Logger{Logger: l.Logger.WithGroup(name)}
+}
+
+func (l *Logger) Trace(msg string, args ...any) {
+	l.log(context.Background(), LevelTrace, msg, args...)
+}
+
+func (l *Logger) TraceContext(ctx context.Context, msg string, args ...any) {
+	l.log(ctx, LevelTrace, msg, args...)
+}
+
+func (l *Logger) Tracef(format string, args ...any) {
+	l.log(context.Background(), LevelTrace, fmt.Sprintf(format, args...))
+}
+
+func (l *Logger) Fatal(msg string, args ...any) {
+	l.log(context.Background(), LevelFatal, msg, args...)
+}
+
+func (l *Logger) FatalContext(ctx context.Context, msg string, args ...any) {
+	l.log(ctx, LevelFatal, msg, args...)
+}
+
+func (l *Logger) Fatalf(format string, args ...any) {
+	l.log(context.Background(), LevelFatal, fmt.Sprintf(format, args...))
+}
+
+// log is adapted from the stdlib slog.Logger.log. It must be called directly
+// by the exported methods so the caller skip is correct
+func (l *Logger) log(ctx context.Context, level slog.Level, msg string, args ...any) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if !l.Enabled(ctx, level) {
+		return
+	}
+
+	// skip [log, exported method]
+	r := NewRecord(time.Now(), level, msg, 2)
+	r.Add(args...)
+	_ = l.Handler().Handle(ctx, r)
+}
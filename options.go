@@ -1,13 +1,57 @@
 package shandler
 
 import (
+	"context"
 	"io"
 	"log/slog"
+	"maps"
+	"time"
 )
 
 func WithJSON() HandlerOption {
 	return func(h *Handler) {
 		h.json = true
+		h.logfmt = false
+		h.syslog = nil
+	}
+}
+
+// WithDuplicateKeys sets how the JSON output handles attributes that share a
+// key within the same object. The default, DuplicateKeysAllow, writes every
+// attribute
+func WithDuplicateKeys(policy DuplicateKeyPolicy) HandlerOption {
+	return func(h *Handler) {
+		h.duplicateKeys = policy
+	}
+}
+
+// WithLogfmt enables logfmt output, e.g.
+// level=info time=15:04:05 msg="hello world" key=value
+//
+// Values are quoted and escaped when needed and nested groups are flattened
+// into dotted keys. WithLogfmt, WithJSON and WithSyslog are mutually
+// exclusive, the last one set wins
+func WithLogfmt() HandlerOption {
+	return func(h *Handler) {
+		h.logfmt = true
+		h.json = false
+		h.syslog = nil
+	}
+}
+
+// WithSyslog writes records as syslog messages, RFC 5424 by default or RFC
+// 3164. Levels are mapped to syslog severities, LevelTrace and DEBUG to
+// debug up to LevelFatal to critical. WithPid sets the PROCID. In RFC 5424
+// the attributes are written as structured data, with groups flattened into
+// dotted names.
+//
+// Send the output to a syslog server with a SyslogWriter
+func WithSyslog(opts SyslogOptions) HandlerOption {
+	return func(h *Handler) {
+		opts = syslogDefaults(opts)
+		h.syslog = &opts
+		h.json = false
+		h.logfmt = false
 	}
 }
 
@@ -29,6 +73,77 @@ func WithStdErr(err ...io.Writer) HandlerOption {
 	}
 }
 
+// WithFile writes both standard and error output to a RotatingFile at path.
+// Use NewRotatingFile with WithStdOut and WithStdErr to send the streams to
+// different files or to keep a reference to the file
+func WithFile(path string, opts RotatingFileOptions) HandlerOption {
+	return func(h *Handler) {
+		f := NewRotatingFile(path, opts)
+		h.out = []io.Writer{f}
+		h.err = []io.Writer{f}
+	}
+}
+
+// WithDedup collapses runs of identical records, with the same level,
+// message, group and attributes, into the first one. When a different record
+// is logged, or window expires, a syslog style "last message repeated N
+// times" record is logged through the root handler, with the group of the
+// run if any. A window of 0 or less only ends a run when a different record
+// is logged
+func WithDedup(window time.Duration) HandlerOption {
+	return func(h *Handler) {
+		h.dedup = true
+		h.dedupWindow = window
+	}
+}
+
+// WithSampling drops records on high volume paths. Records are counted per
+// level and message; in every tick the first opts.First are logged, then
+// every opts.Thereafter-th. As soon as a tick in which records were dropped
+// is over, a WARN record with the number of records suppressed is logged.
+// Handler.Suppressed reports the total. FATAL records are never dropped
+func WithSampling(opts SamplingOptions) HandlerOption {
+	return func(h *Handler) {
+		h.sampling = &opts
+	}
+}
+
+// WithRateLimit caps the records written by the handler and every handler
+// derived from it with a token bucket allowing rate records per second with
+// bursts of up to burst records. Records over the limit are dropped and
+// reported, per group, by a WARN record when the second they were dropped
+// in is over.
+// Handler.RateLimited reports the total. FATAL records are never dropped
+func WithRateLimit(rate float64, burst int) HandlerOption {
+	return func(h *Handler) {
+		h.rateLimit = &rateLimit{Rate: rate, Burst: burst}
+	}
+}
+
+// WithGroupRateLimit works like WithRateLimit with a token bucket for every
+// group path, so one chatty group can not use up the limit of the others.
+// Both can be set, a record then has to be within both limits
+func WithGroupRateLimit(rate float64, burst int) HandlerOption {
+	return func(h *Handler) {
+		h.groupRateLimit = &rateLimit{Rate: rate, Burst: burst}
+	}
+}
+
+// WithAsync moves writes to a background goroutine so a slow io.Writer does
+// not block the goroutine logging. Records are formatted when they are logged
+// and queued, up to queueSize of them; a queueSize of 0 or less disables
+// async mode. policy decides what happens when the
+// queue is full; Handler.Dropped reports how many records were dropped.
+//
+// Call Handler.Flush or Handler.Close before the program exits so queued
+// records are written. Write errors are ignored in async mode
+func WithAsync(queueSize int, policy AsyncPolicy) HandlerOption {
+	return func(h *Handler) {
+		h.asyncSize = queueSize
+		h.asyncPolicy = policy
+	}
+}
+
 func WithTimeFormat(format string) HandlerOption {
 	return func(h *Handler) {
 		h.timeFormat = format
@@ -38,7 +153,27 @@ func WithTimeFormat(format string) HandlerOption {
 func WithLineInfo(short bool) HandlerOption {
 	return func(h *Handler) {
 		h.lineInfo = true
-		h.lineInfoShort = short
+		h.lineInfoFormat = LineInfoLong
+		if short {
+			h.lineInfoFormat = LineInfoShort
+		}
+	}
+}
+
+// WithLineInfoFormat adds the caller to every record using one of the
+// LineInfoFormat layouts
+func WithLineInfoFormat(format LineInfoFormat) HandlerOption {
+	return func(h *Handler) {
+		h.lineInfo = true
+		h.lineInfoFormat = format
+	}
+}
+
+// WithCallerSkip reports the caller skip frames above the one slog recorded.
+// Use it when the handler sits behind wrappers that call slog.Logger for you
+func WithCallerSkip(skip int) HandlerOption {
+	return func(h *Handler) {
+		h.callerSkip = skip
 	}
 }
 
@@ -82,6 +217,20 @@ func WithLogLevel(level slog.Level) HandlerOption {
 	}
 }
 
+// WithLeveler sets the minimum level using a slog.Leveler, such as a
+// *slog.LevelVar, so the level can be changed while the program is running.
+// Handlers derived with WithAttrs or WithGroup observe the change immediately.
+//
+// ToConfig stores the level that is current when it is called
+func WithLeveler(leveler slog.Leveler) HandlerOption {
+	return func(h *Handler) {
+		if leveler == nil {
+			leveler = slog.LevelInfo
+		}
+		h.level = leveler
+	}
+}
+
 func WithColor() HandlerOption {
 	return func(h *Handler) {
 		h.color = true
@@ -130,13 +279,130 @@ func WithShortLevels() HandlerOption {
 	}
 }
 
-// You can use this to filter out logs from specific groups
+// You can use this to filter out logs from specific groups. A group also
+// filters out every group nested in it, "db" filters "db.pool" too
 func WithGroupFilter(filter []string) HandlerOption {
 	return func(h *Handler) {
 		h.groupFilter = filter
 	}
 }
 
+// WithGroupLevels sets the minimum level for specific groups, overriding the
+// handler level. Keys are matched against the dotted group path, e.g.
+// "db.pool", and may be an exact path, a parent group ("db" also matches
+// "db.pool") or a path.Match pattern such as "http.*" or "*". The longest
+// matching key wins. Levels are resolved when the group is created, so
+// records from a quieted group are rejected in Enabled.
+func WithGroupLevels(levels map[string]slog.Level) HandlerOption {
+	return func(h *Handler) {
+		h.groupLevels = maps.Clone(levels)
+	}
+}
+
+// WithRedaction adds rules redacting sensitive values by key, by value or,
+// for types implementing Redactor, by type. Rules apply to the attributes of
+// every group, after WithReplaceAttr, and value rules to the message too. The
+// first key rule matching an attribute wins; use DefaultRedactRules for
+// common secrets
+func WithRedaction(rules ...RedactRule) HandlerOption {
+	return func(h *Handler) {
+		h.redactRules = append(h.redactRules, rules...)
+	}
+}
+
+// WithPseudonymization replaces the values of attributes with a key matching
+// one of the case-insensitive glob patterns, checked against the key and its
+// dotted group path, with a stable token derived by p. It is applied before
+// the WithRedaction rules.
+//
+// The patterns are part of the config returned by ToConfig, the Pseudonymizer
+// holds secrets and is not. Pass it to NewHandlerFromConfig with
+// WithPseudonymization(p) and no patterns
+func WithPseudonymization(p *Pseudonymizer, patterns ...string) HandlerOption {
+	return func(h *Handler) {
+		h.pseudonymizer = p
+		h.pseudonymAttrs = append(h.pseudonymAttrs, patterns...)
+	}
+}
+
+// WithContextExtractor adds a function returning attributes from the context
+// passed to Handle, such as request or tenant IDs. They are appended to the
+// top level of every record, after the attributes added with ContextWith.
+// Extractors run in the order they were added
+func WithContextExtractor(extract func(ctx context.Context) []slog.Attr) HandlerOption {
+	return func(h *Handler) {
+		h.contextExtractors = append(h.contextExtractors, extract)
+	}
+}
+
+// WithTraceContext adds trace_id, span_id and trace_flags attributes to
+// records logged with a context carrying a trace. A nil extractor uses
+// TraceparentExtractor, which reads the W3C traceparent stored with
+// ContextWithTraceparent
+func WithTraceContext(extractor TraceExtractor) HandlerOption {
+	return func(h *Handler) {
+		if extractor == nil {
+			extractor = TraceparentExtractor
+		}
+		h.traceExtractor = extractor
+	}
+}
+
+// WithReplaceAttr sets a function that is called to rewrite each attribute
+// before it is logged, with the same contract as slog.HandlerOptions.ReplaceAttr.
+// It is called for the built-in slog.LevelKey, slog.TimeKey and
+// slog.MessageKey attributes, with a nil groups slice, and for the
+// "slog_info" and "error_id" attributes. Returning an attribute with an empty
+// key drops it.
+//
+// The output modes use the replaced built-in attributes differently:
+//   - text uses only their values, the keys are not written
+//   - JSON and logfmt write them with their new key and value
+//   - syslog writes them in fixed header fields, so the keys are ignored and a
+//     level or time is only used while its value is still a slog.Level or a
+//     time.Time. A dropped level keeps the record level, a dropped time is
+//     written as the nil value
+func WithReplaceAttr(replace func(groups []string, a slog.Attr) slog.Attr) HandlerOption {
+	return func(h *Handler) {
+		h.replaceAttr = replace
+	}
+}
+
+// WithFatalExit makes a record logged at LevelFatal or above terminate the
+// process. The record is written, the handler is flushed, the shutdown hooks
+// run and then os.Exit is called with code. The flush and the hooks share the
+// WithShutdownTimeout timeout, 5 seconds by default
+func WithFatalExit(code int) HandlerOption {
+	return func(h *Handler) {
+		h.fatalExit = true
+		h.fatalExitCode = code
+	}
+}
+
+// WithShutdownHook adds a function that is run before the process exits on a
+// fatal record. Hooks run in the order they were added and should return when
+// ctx is done
+func WithShutdownHook(hook func(ctx context.Context)) HandlerOption {
+	return func(h *Handler) {
+		h.shutdownHooks = append(h.shutdownHooks, hook)
+	}
+}
+
+// WithShutdownTimeout sets how long the flush and shutdown hooks can take
+// before the process exits on a fatal record
+func WithShutdownTimeout(timeout time.Duration) HandlerOption {
+	return func(h *Handler) {
+		h.shutdownTimeout = timeout
+	}
+}
+
+// WithExitFunc replaces os.Exit for WithFatalExit, mostly useful in tests
+func WithExitFunc(exit func(code int)) HandlerOption {
+	return func(h *Handler) {
+		h.exitFunc = exit
+	}
+}
+
 // The handler will append a "error_id" field to the log record
 // with a unique id for the error for easier tracking
 func WithErrorTag() HandlerOption {
@@ -144,3 +410,30 @@ func WithErrorTag() HandlerOption {
 		h.errorTag = true
 	}
 }
+
+// WithStackTrace adds a "stack" attribute with the stack of the log site to
+// ERROR and FATAL records, keeping at most depth frames, 32 when depth is 0
+// or less. Runtime, log/slog and handler frames are left out.
+//
+// The text output writes the frames as an indented block below the record,
+// the JSON output as an array of {"func","file","line"} objects
+func WithStackTrace(depth int) HandlerOption {
+	return func(h *Handler) {
+		h.stackTrace = true
+		h.stackDepth = depth
+	}
+}
+
+// WithRichErrors writes error attributes with their errors.Unwrap chain and
+// the members of joined errors instead of only their message. typeNames adds
+// the Go type of every error.
+//
+// The JSON output writes an object,
+// {"msg":"...","type":"...","chain":[...],"errors":[...]}, the text and logfmt
+// output a single line with the chain separated by ->
+func WithRichErrors(typeNames bool) HandlerOption {
+	return func(h *Handler) {
+		h.richErrors = true
+		h.errorTypes = typeNames
+	}
+}
@@ -1,28 +1,25 @@
 package shandler
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"strings"
+	"sync"
 )
 
-func printer(src []io.Writer, data ...any) {
-	for _, s := range src {
-		fmt.Fprintln(s, data...)
-	}
+func printer(n *Handler, src []io.Writer, data ...any) error {
+	return n.write(src, []byte(fmt.Sprintln(data...)))
 }
 
-func printerf(src []io.Writer, pid string, format string, data ...any) {
-	for _, s := range src {
-		if pid == "" {
-			fmt.Fprintf(s, format, data...)
-		} else {
-			fmt.Fprintf(s, "["+pid+"] "+format, data...)
-		}
+func printerf(n *Handler, src []io.Writer, pid, block string, format string, data ...any) error {
+	if pid != "" {
+		format = "[" + pid + "] " + format
 	}
+	return n.write(src, appendBlock([]byte(fmt.Sprintf(format, data...)), block))
 }
 
-func printerrj(src []io.Writer, g, pid, format string, data ...any) {
+func printerrj(n *Handler, src []io.Writer, g, pid, block, format string, data ...any) error {
 	var left string
 	if pid == "" {
 		left = fmt.Sprintf(strings.TrimSpace(format), data...)
@@ -35,7 +32,42 @@ func printerrj(src []io.Writer, g, pid, format string, data ...any) {
 		rightWidth = 0
 	}
 
+	return n.write(src, appendBlock([]byte(fmt.Sprintf("%s%*s\n", strings.TrimSpace(left), rightWidth, g)), block))
+}
+
+// appendBlock adds lines written below a record, such as a stack trace, so
+// they go out with the record in a single write
+func appendBlock(b []byte, block string) []byte {
+	if block == "" {
+		return b
+	}
+	if len(b) > 0 && b[len(b)-1] != '\n' {
+		b = append(b, '\n')
+	}
+	return append(b, block...)
+}
+
+// write sends the fully formatted line to every writer, or queues it for the
+// background writer in async mode
+func (n *Handler) write(src []io.Writer, b []byte) error {
+	if n.async != nil && n.async.enqueue(src, b) {
+		return nil
+	}
+	return writeAll(n.mu, src, b)
+}
+
+// writeAll sends b to every writer with a single Write call each. The lock is
+// shared by a handler and all of its clones so lines written from different
+// goroutines never interleave.
+func writeAll(mu *sync.Mutex, src []io.Writer, b []byte) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	var errs []error
 	for _, s := range src {
-		fmt.Fprintf(s, "%s%*s\n", strings.TrimSpace(left), rightWidth, g)
+		if _, err := s.Write(b); err != nil {
+			errs = append(errs, err)
+		}
 	}
+	return errors.Join(errs...)
 }
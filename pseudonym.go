@@ -0,0 +1,90 @@
+package shandler
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// pseudonymSize is the number of bytes of the HMAC kept in a token
+const pseudonymSize = 12
+
+// PseudonymKey is a secret used to derive pseudonyms. The ID is written in
+// every token so the key a token was derived with is known after rotation
+type PseudonymKey struct {
+	ID     string
+	Secret []byte
+}
+
+// Pseudonymizer replaces personal data with stable tokens,
+// <key id>:<hex HMAC-SHA256 of the value>. The same value always gives the
+// same token for a given key so records can still be correlated, but the
+// value can't be recovered without the key
+type Pseudonymizer struct {
+	current PseudonymKey
+	keys    map[string][]byte
+}
+
+// NewPseudonymizer returns a Pseudonymizer deriving tokens with current.
+// Keys that were rotated out can be passed as previous so Verify still
+// matches tokens in older logs
+func NewPseudonymizer(current PseudonymKey, previous ...PseudonymKey) *Pseudonymizer {
+	p := &Pseudonymizer{current: current, keys: map[string][]byte{}}
+	for _, k := range previous {
+		p.keys[k.ID] = k.Secret
+	}
+	p.keys[current.ID] = current.Secret
+	return p
+}
+
+// Token returns the token for value using the current key
+func (p *Pseudonymizer) Token(value string) string {
+	return PseudonymToken(p.current, value)
+}
+
+// Verify reports whether token was derived from value with one of the keys
+// of the Pseudonymizer
+func (p *Pseudonymizer) Verify(token, value string) bool {
+	// key IDs may contain colons, the HMAC doesn't
+	i := strings.LastIndex(token, ":")
+	if i < 0 {
+		return false
+	}
+	id := token[:i]
+	secret, ok := p.keys[id]
+	if !ok {
+		return false
+	}
+	return hmac.Equal([]byte(token), []byte(PseudonymToken(PseudonymKey{ID: id, Secret: secret}, value)))
+}
+
+// PseudonymToken returns the token for value derived with key, the same
+// token a Pseudonymizer using key writes to the logs
+func PseudonymToken(key PseudonymKey, value string) string {
+	mac := hmac.New(sha256.New, key.Secret)
+	mac.Write([]byte(value))
+	return fmt.Sprintf("%s:%x", key.ID, mac.Sum(nil)[:pseudonymSize])
+}
+
+// pseudonymizeAttrs replaces the values of the attributes with a key
+// matching one of the patterns with their token, in every group
+func pseudonymizeAttrs(p *Pseudonymizer, patterns []string, prefix string, attrs []slog.Attr) []slog.Attr {
+	ret := make([]slog.Attr, 0, len(attrs))
+	for _, a := range attrs {
+		keyPath := prefix + a.Key
+		if a.Value.Kind() == slog.KindGroup {
+			a.Value = slog.GroupValue(pseudonymizeAttrs(p, patterns, keyPath+".", a.Value.Group())...)
+		} else {
+			for _, pattern := range patterns {
+				if (RedactRule{Key: pattern}).matchKey(a.Key, keyPath) {
+					a.Value = slog.StringValue(p.Token(a.Value.String()))
+					break
+				}
+			}
+		}
+		ret = append(ret, a)
+	}
+	return ret
+}
@@ -0,0 +1,189 @@
+package shandler
+
+import (
+	"context"
+	"log/slog"
+	"maps"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// rateLimitReportInterval is how often the records dropped by WithRateLimit
+// and WithGroupRateLimit are reported
+const rateLimitReportInterval = time.Second
+
+// rateLimit is the configuration of a token bucket, rate tokens are added
+// every second up to burst
+type rateLimit struct {
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// refill adds the tokens earned since the last refill and reports whether a
+// token can be taken
+func (b *tokenBucket) refill(limit rateLimit, now time.Time) bool {
+	b.tokens += now.Sub(b.last).Seconds() * limit.Rate
+	b.tokens = min(b.tokens, float64(limit.Burst))
+	b.last = now
+	return b.tokens >= 1
+}
+
+// rateLimiter holds the token buckets of WithRateLimit and
+// WithGroupRateLimit. It is shared by a handler and every handler derived
+// from it. Dropped records are counted per group and reported through root,
+// the handler created by NewHandler, once every interval in which records
+// were dropped is over
+type rateLimiter struct {
+	root       *Handler
+	limit      *rateLimit
+	groupLimit *rateLimit
+	interval   time.Duration
+
+	mu         sync.Mutex
+	bucket     *tokenBucket
+	groups     map[string]*tokenBucket
+	dropped    map[string]uint64
+	lastReport time.Time
+	// timer reports the drops when the interval is over, gen tells a timer
+	// that fires late that its drops were already reported
+	timer *time.Timer
+	gen   uint64
+
+	total atomic.Uint64
+	now   func() time.Time
+}
+
+func newRateLimiter(root *Handler, limit, groupLimit *rateLimit) *rateLimiter {
+	return &rateLimiter{
+		root:       root,
+		limit:      limit,
+		groupLimit: groupLimit,
+		interval:   rateLimitReportInterval,
+		groups:     map[string]*tokenBucket{},
+		dropped:    map[string]uint64{},
+		now:        time.Now,
+	}
+}
+
+// allow reports whether a record logged in group is within the limits
+func (r *rateLimiter) allow(ctx context.Context, group string) bool {
+	r.mu.Lock()
+	now := r.now()
+	if r.lastReport.IsZero() {
+		r.lastReport = now
+	}
+
+	// a token is only taken when both buckets have one, so a record dropped
+	// by one limit doesn't use up the other
+	ok := true
+	var groupBucket *tokenBucket
+	if r.groupLimit != nil {
+		b, found := r.groups[group]
+		if !found {
+			b = &tokenBucket{tokens: float64(r.groupLimit.Burst), last: now}
+			r.groups[group] = b
+		}
+		ok = b.refill(*r.groupLimit, now) && ok
+		groupBucket = b
+	}
+	if r.limit != nil {
+		if r.bucket == nil {
+			r.bucket = &tokenBucket{tokens: float64(r.limit.Burst), last: now}
+		}
+		ok = r.bucket.refill(*r.limit, now) && ok
+	}
+	if ok {
+		if groupBucket != nil {
+			groupBucket.tokens--
+		}
+		if r.limit != nil {
+			r.bucket.tokens--
+		}
+	}
+	var dropped map[string]uint64
+	if now.Sub(r.lastReport) >= r.interval {
+		dropped = r.takeDropped(now)
+	}
+	if !ok {
+		r.dropped[group]++
+		r.total.Add(1)
+		if r.timer == nil {
+			gen := r.gen
+			r.timer = time.AfterFunc(r.lastReport.Add(r.interval).Sub(now), func() {
+				r.expire(gen)
+			})
+		}
+	}
+	r.mu.Unlock()
+
+	r.report(ctx, dropped)
+	return ok
+}
+
+// expire reports the drops of the interval whose timer is generation gen
+func (r *rateLimiter) expire(gen uint64) {
+	r.mu.Lock()
+	if gen != r.gen {
+		r.mu.Unlock()
+		return
+	}
+	dropped := r.takeDropped(r.now())
+	r.mu.Unlock()
+
+	r.report(context.Background(), dropped)
+}
+
+// flush reports the records dropped since the last report
+func (r *rateLimiter) flush(ctx context.Context) {
+	r.mu.Lock()
+	dropped := r.takeDropped(r.now())
+	r.mu.Unlock()
+
+	r.report(ctx, dropped)
+}
+
+// takeDropped returns the records dropped since the last report, per group,
+// and stops the timer that would report them
+func (r *rateLimiter) takeDropped(now time.Time) map[string]uint64 {
+	if r.timer != nil {
+		r.timer.Stop()
+		r.timer = nil
+	}
+	r.gen++
+
+	r.lastReport = now
+	if len(r.dropped) == 0 {
+		return nil
+	}
+	dropped := r.dropped
+	r.dropped = map[string]uint64{}
+	return dropped
+}
+
+// report logs a WARN record for every group records were dropped in
+func (r *rateLimiter) report(ctx context.Context, dropped map[string]uint64) {
+	for _, group := range slices.Sorted(maps.Keys(dropped)) {
+		record := slog.NewRecord(r.now(), slog.LevelWarn, "records dropped by rate limit", 0)
+		record.AddAttrs(slog.Uint64("dropped", dropped[group]))
+		if group != "" {
+			record.AddAttrs(slog.String("group", group))
+		}
+		_ = r.root.handle(ctx, record)
+	}
+}
+
+// RateLimited returns the number of records dropped by WithRateLimit and
+// WithGroupRateLimit
+func (n *Handler) RateLimited() uint64 {
+	if n.rateLimiter == nil {
+		return 0
+	}
+	return n.rateLimiter.total.Load()
+}
@@ -0,0 +1,90 @@
+package shandler
+
+import (
+	"bytes"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimit(t *testing.T) {
+	var stdout bytes.Buffer
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	h := NewHandler(WithStdOut(&stdout), WithTextOutputFormat("%[3]s\n"), WithRateLimit(2, 1))
+	h.rateLimiter.now = func() time.Time { return now }
+	logger := slog.New(h)
+
+	logger.Info("1")
+	logger.Info("2")
+	now = now.Add(250 * time.Millisecond)
+	logger.Info("3")
+	now = now.Add(250 * time.Millisecond)
+	logger.Info("4")
+	logger.Info("5")
+	now = now.Add(500 * time.Millisecond)
+	logger.Info("6")
+
+	// the bucket refills a token every 500ms, the drops are reported once a
+	// second before the record that triggered the report
+	assert.Equal(t, "1\n4\nrecords dropped by rate limit dropped=3\n6\n", stdout.String())
+	assert.Equal(t, uint64(3), h.RateLimited())
+}
+
+func TestRateLimitBothBuckets(t *testing.T) {
+	var stdout bytes.Buffer
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	h := NewHandler(WithStdOut(&stdout), WithTextOutputFormat("%[3]s\n"), WithRateLimit(2, 1), WithGroupRateLimit(2, 2))
+	h.rateLimiter.now = func() time.Time { return now }
+	logger := slog.New(h).WithGroup("db")
+
+	logger.Info("1")
+	logger.Info("2")
+	// the handler bucket dropped 2, the group bucket keeps its token
+	assert.Equal(t, 1.0, h.rateLimiter.groups["db"].tokens)
+	assert.Equal(t, 0.0, h.rateLimiter.bucket.tokens)
+
+	now = now.Add(500 * time.Millisecond)
+	logger.Info("3")
+	logger.Info("4")
+	assert.Equal(t, "db | 1\ndb | 3\n", stdout.String())
+	assert.Equal(t, 1.0, h.rateLimiter.groups["db"].tokens)
+	assert.Equal(t, uint64(2), h.RateLimited())
+}
+
+// lockedBuffer is a bytes.Buffer that can be read while a timer writes to it
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestRateLimitTimer(t *testing.T) {
+	var stdout lockedBuffer
+	h := NewHandler(WithStdOut(&stdout), WithTextOutputFormat("%[3]s\n"), WithGroupRateLimit(0.001, 1))
+	h.rateLimiter.interval = 20 * time.Millisecond
+	logger := slog.New(h).WithGroup("db")
+
+	logger.Info("query")
+	logger.Info("query")
+	logger.Info("query")
+	assert.Eventually(t, func() bool {
+		return stdout.String() == "db | query\nrecords dropped by rate limit dropped=2 group=db\n"
+	}, time.Second, 5*time.Millisecond)
+	assert.Nil(t, h.Close())
+}
@@ -0,0 +1,306 @@
+package shandler
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"log/slog"
+	"path"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// RedactMode is what happens to a value matched by a redaction rule
+type RedactMode int
+
+const (
+	// RedactMask replaces the value with [REDACTED]
+	RedactMask RedactMode = iota
+	// RedactHash replaces the value with the start of its SHA-256 hash,
+	// sha256:2c26b46b68ffc68f, so equal values can still be correlated
+	RedactHash
+	// RedactTruncate keeps the first 4 characters of values longer than 8
+	// characters, abcd..., and masks shorter ones
+	RedactTruncate
+	// RedactDrop removes the attribute
+	RedactDrop
+)
+
+// RedactedMask replaces values redacted with RedactMask
+const RedactedMask = "[REDACTED]"
+
+// Patterns for common secrets and personal data, for use with RedactValue.
+// Matches of CreditCardPattern are only redacted when they pass the Luhn
+// check, so timestamps and IDs with as many digits are left alone
+var (
+	CreditCardPattern   = regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`)
+	JWTPattern          = regexp.MustCompile(`\beyJ[A-Za-z0-9_-]+\.eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`)
+	AWSAccessKeyPattern = regexp.MustCompile(`\b(?:AKIA|ASIA)[A-Z0-9]{16}\b`)
+	EmailPattern        = regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)
+)
+
+// valueChecks validate the matches of patterns a regular expression can't
+// check alone. They are looked up by pattern so they still apply to rules
+// restored with NewHandlerFromConfig
+var valueChecks = map[string]func(string) bool{
+	CreditCardPattern.String(): luhnValid,
+}
+
+// luhnValid reports whether the digits of s pass the Luhn checksum used by
+// card numbers
+func luhnValid(s string) bool {
+	sum, double := 0, false
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return sum%10 == 0
+}
+
+// defaultRedactKeys are the keys masked by DefaultRedactRules
+var defaultRedactKeys = []string{"*password*", "*passwd*", "*secret*", "*token*", "*api_key*", "*apikey*", "authorization", "cookie", "set-cookie"}
+
+// Redactor is implemented by types holding sensitive data. Values of these
+// types are always redacted with the mode returned by Redact, e.g.
+//
+//	type Password string
+//
+//	func (Password) Redact() shandler.RedactMode { return shandler.RedactMask }
+type Redactor interface {
+	Redact() RedactMode
+}
+
+// RedactRule selects values to redact, by attribute key or by value. Build
+// rules with RedactKey, RedactKeyRegexp and RedactValue
+type RedactRule struct {
+	// Key is a case-insensitive path.Match glob matched against the key of
+	// an attribute and its dotted path, e.g. *password* or req.authorization
+	Key string
+	// KeyRegexp is matched against the key of an attribute and its dotted path
+	KeyRegexp *regexp.Regexp
+	// Value is matched against string values and the message. Only the
+	// matching parts are redacted, unless Mode is RedactDrop which drops
+	// the whole attribute
+	Value *regexp.Regexp
+	Mode  RedactMode
+}
+
+// RedactKey redacts attributes with a key matching the case-insensitive
+// glob pattern
+func RedactKey(pattern string, mode RedactMode) RedactRule {
+	return RedactRule{Key: pattern, Mode: mode}
+}
+
+// RedactKeyRegexp redacts attributes with a key matching re
+func RedactKeyRegexp(re *regexp.Regexp, mode RedactMode) RedactRule {
+	return RedactRule{KeyRegexp: re, Mode: mode}
+}
+
+// RedactValue redacts the parts of string values matching re
+func RedactValue(re *regexp.Regexp, mode RedactMode) RedactRule {
+	return RedactRule{Value: re, Mode: mode}
+}
+
+// DefaultRedactRules masks common secret keys such as passwords, tokens and
+// authorization headers, as well as credit card numbers passing the Luhn
+// check, JWTs and AWS access keys in values
+func DefaultRedactRules() []RedactRule {
+	rules := make([]RedactRule, 0, len(defaultRedactKeys)+3)
+	for _, k := range defaultRedactKeys {
+		rules = append(rules, RedactKey(k, RedactMask))
+	}
+	return append(rules,
+		RedactValue(CreditCardPattern, RedactMask),
+		RedactValue(JWTPattern, RedactMask),
+		RedactValue(AWSAccessKeyPattern, RedactMask),
+	)
+}
+
+// replaceValue redacts the matches of the value rule in s with mode. It
+// reports whether anything was redacted
+func (r RedactRule) replaceValue(s string, mode RedactMode) (string, bool) {
+	check := valueChecks[r.Value.String()]
+	matched := false
+	s = r.Value.ReplaceAllStringFunc(s, func(m string) string {
+		if check != nil && !check(m) {
+			return m
+		}
+		matched = true
+		return redactString(m, mode)
+	})
+	return s, matched
+}
+
+func (r RedactRule) matchKey(key, keyPath string) bool {
+	if r.Key != "" {
+		pattern := strings.ToLower(r.Key)
+		if ok, _ := path.Match(pattern, strings.ToLower(key)); ok {
+			return true
+		}
+		if ok, _ := path.Match(pattern, strings.ToLower(keyPath)); ok {
+			return true
+		}
+	}
+	if r.KeyRegexp != nil {
+		return r.KeyRegexp.MatchString(key) || r.KeyRegexp.MatchString(keyPath)
+	}
+	return false
+}
+
+// redactAttrs applies rules to attrs and, recursively, to every group.
+// Groups left without any attributes are dropped
+func redactAttrs(rules []RedactRule, prefix string, attrs []slog.Attr) []slog.Attr {
+	ret := make([]slog.Attr, 0, len(attrs))
+attrs:
+	for _, a := range attrs {
+		keyPath := prefix + a.Key
+		if a.Value.Kind() == slog.KindGroup {
+			group := redactAttrs(rules, keyPath+".", a.Value.Group())
+			if len(group) != 0 {
+				ret = append(ret, slog.Attr{Key: a.Key, Value: slog.GroupValue(group...)})
+			}
+			continue
+		}
+
+		for _, r := range rules {
+			if r.matchKey(a.Key, keyPath) {
+				if r.Mode == RedactDrop {
+					continue attrs
+				}
+				ret = append(ret, slog.String(a.Key, redactString(a.Value.String(), r.Mode)))
+				continue attrs
+			}
+		}
+
+		if a.Value.Kind() == slog.KindAny {
+			if rd, ok := a.Value.Any().(Redactor); ok {
+				mode := rd.Redact()
+				if mode == RedactDrop {
+					continue
+				}
+				ret = append(ret, slog.String(a.Key, redactString(a.Value.String(), mode)))
+				continue
+			}
+		}
+
+		if a.Value.Kind() == slog.KindString || a.Value.Kind() == slog.KindAny {
+			s, changed, drop := redactValue(rules, a.Value.String())
+			if drop {
+				continue
+			}
+			if changed {
+				a.Value = slog.StringValue(s)
+			}
+		}
+		ret = append(ret, a)
+	}
+	return ret
+}
+
+// redactValue applies the value rules to s. drop is set when a RedactDrop
+// rule matched
+func redactValue(rules []RedactRule, s string) (ret string, changed, drop bool) {
+	for _, r := range rules {
+		if r.Value == nil || !r.Value.MatchString(s) {
+			continue
+		}
+		redacted, matched := r.replaceValue(s, r.Mode)
+		if !matched {
+			continue
+		}
+		if r.Mode == RedactDrop {
+			return "", true, true
+		}
+		s = redacted
+		changed = true
+	}
+	return s, changed, false
+}
+
+// redactMessage applies the value rules to the record message, RedactDrop
+// rules mask the matches
+func redactMessage(rules []RedactRule, msg string) string {
+	for _, r := range rules {
+		if r.Value == nil {
+			continue
+		}
+		mode := r.Mode
+		if mode == RedactDrop {
+			mode = RedactMask
+		}
+		msg, _ = r.replaceValue(msg, mode)
+	}
+	return msg
+}
+
+func redactString(s string, mode RedactMode) string {
+	switch mode {
+	case RedactHash:
+		sum := sha256.Sum256([]byte(s))
+		return fmt.Sprintf("sha256:%x", sum[:8])
+	case RedactTruncate:
+		if utf8.RuneCountInString(s) > 8 {
+			i := 0
+			for n := 0; n < 4; n++ {
+				_, size := utf8.DecodeRuneInString(s[i:])
+				i += size
+			}
+			return s[:i] + "..."
+		}
+	}
+	return RedactedMask
+}
+
+// redactRuleValue is the serialized form of a RedactRule
+type redactRuleValue struct {
+	Key       string     `json:"key,omitempty"`
+	KeyRegexp string     `json:"key_regexp,omitempty"`
+	Value     string     `json:"value,omitempty"`
+	Mode      RedactMode `json:"mode"`
+}
+
+func toRedactRuleValues(rules []RedactRule) []redactRuleValue {
+	ret := make([]redactRuleValue, 0, len(rules))
+	for _, r := range rules {
+		v := redactRuleValue{Key: r.Key, Mode: r.Mode}
+		if r.KeyRegexp != nil {
+			v.KeyRegexp = r.KeyRegexp.String()
+		}
+		if r.Value != nil {
+			v.Value = r.Value.String()
+		}
+		ret = append(ret, v)
+	}
+	return ret
+}
+
+func fromRedactRuleValues(values []redactRuleValue) ([]RedactRule, error) {
+	ret := make([]RedactRule, 0, len(values))
+	for _, v := range values {
+		r := RedactRule{Key: v.Key, Mode: v.Mode}
+		var err error
+		if v.KeyRegexp != "" {
+			if r.KeyRegexp, err = regexp.Compile(v.KeyRegexp); err != nil {
+				return nil, err
+			}
+		}
+		if v.Value != "" {
+			if r.Value, err = regexp.Compile(v.Value); err != nil {
+				return nil, err
+			}
+		}
+		ret = append(ret, r)
+	}
+	return ret, nil
+}
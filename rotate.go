@@ -0,0 +1,344 @@
+package shandler
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backupTimeFormat is used in the names of rotated files, e.g.
+// app-2006-01-02T15-04-05.000.log. A counter is added when files are rotated
+// within the same millisecond, e.g. app-2006-01-02T15-04-05.000.1.log
+const backupTimeFormat = "2006-01-02T15-04-05.000"
+
+// RotationInterval sets how often a RotatingFile is rotated regardless of
+// its size
+type RotationInterval int
+
+const (
+	RotateNever RotationInterval = iota
+	RotateHourly
+	RotateDaily
+)
+
+// RotatingFileOptions configures a RotatingFile
+type RotatingFileOptions struct {
+	// MaxSize is the size in bytes a file can grow to before it is rotated.
+	// Zero disables size based rotation
+	MaxSize int64
+	// Interval rotates the file at the start of every hour or day
+	Interval RotationInterval
+	// MaxBackups is the number of rotated files to keep. Zero keeps all of them
+	MaxBackups int
+	// MaxAge removes rotated files older than the given duration. Zero keeps
+	// all of them
+	MaxAge time.Duration
+	// Compress gzips rotated files
+	Compress bool
+}
+
+// RotatingFile is an io.WriteCloser that writes to a file and rotates it by
+// size and/or time. Rotated files are renamed with a timestamp, e.g.
+// app.log becomes app-2006-01-02T15-04-05.000.log, and optionally compressed.
+//
+// The file is opened on the first Write so creating a RotatingFile never
+// fails. It is safe for concurrent use.
+type RotatingFile struct {
+	mu   sync.Mutex
+	path string
+	opts RotatingFileOptions
+
+	file   *os.File
+	size   int64
+	period time.Time
+
+	// mill serializes compression and cleanup of rotated files, which run
+	// in the background
+	mill sync.Mutex
+	wg   sync.WaitGroup
+
+	now func() time.Time
+}
+
+// NewRotatingFile returns a RotatingFile writing to path. Missing parent
+// directories are created when the file is opened
+func NewRotatingFile(path string, opts RotatingFileOptions) *RotatingFile {
+	return &RotatingFile{
+		path: path,
+		opts: opts,
+		now:  time.Now,
+	}
+}
+
+func (r *RotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file == nil {
+		if err := r.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	now := r.now()
+	sizeExceeded := r.opts.MaxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.opts.MaxSize
+	if sizeExceeded || r.periodStart(now).After(r.period) {
+		if err := r.rotate(now); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// Rotate closes the current file, renames it with a timestamp and opens a
+// new one. Nothing is done before the first write or when nothing was written
+// since the last rotation, so no empty backups are made
+func (r *RotatingFile) Rotate() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file == nil || r.size == 0 {
+		return nil
+	}
+	return r.rotate(r.now())
+}
+
+// Close closes the file and waits for any background compression or cleanup
+// to finish
+func (r *RotatingFile) Close() error {
+	r.mu.Lock()
+	var err error
+	if r.file != nil {
+		err = r.file.Close()
+		r.file = nil
+	}
+	r.mu.Unlock()
+
+	r.wg.Wait()
+	return err
+}
+
+// Sync commits the current file to stable storage
+func (r *RotatingFile) Sync() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file == nil {
+		return nil
+	}
+	return r.file.Sync()
+}
+
+func (r *RotatingFile) open() error {
+	if err := os.MkdirAll(filepath.Dir(r.path), 0o755); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	r.file = f
+	r.size = info.Size()
+	r.period = r.periodStart(r.now())
+	if r.size > 0 {
+		r.period = r.periodStart(info.ModTime())
+	}
+	return nil
+}
+
+func (r *RotatingFile) rotate(now time.Time) error {
+	if err := r.file.Close(); err != nil {
+		return err
+	}
+	r.file = nil
+
+	if err := os.Rename(r.path, r.backupName(now)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	if err := r.open(); err != nil {
+		return err
+	}
+
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		r.millRun(now)
+	}()
+	return nil
+}
+
+// periodStart returns the start of the rotation interval t falls in, or the
+// zero time if time based rotation is disabled
+func (r *RotatingFile) periodStart(t time.Time) time.Time {
+	switch r.opts.Interval {
+	case RotateHourly:
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, t.Location())
+	case RotateDaily:
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	}
+	return time.Time{}
+}
+
+// backupName returns the name the current file is renamed to when rotated
+// at t. os.Rename replaces an existing file, so a counter is added to the
+// name of a file rotated within the same millisecond as a previous one
+func (r *RotatingFile) backupName(t time.Time) string {
+	dir, prefix, ext := r.nameParts()
+	name := prefix + t.Format(backupTimeFormat)
+	path := filepath.Join(dir, name+ext)
+	for i := 1; backupExists(path); i++ {
+		path = filepath.Join(dir, name+"."+strconv.Itoa(i)+ext)
+	}
+	return path
+}
+
+// backupExists reports whether a rotated file is at path, compressed or not
+func backupExists(path string) bool {
+	for _, p := range []string{path, path + ".gz"} {
+		if _, err := os.Lstat(p); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *RotatingFile) nameParts() (dir, prefix, ext string) {
+	dir = filepath.Dir(r.path)
+	base := filepath.Base(r.path)
+	ext = filepath.Ext(base)
+	prefix = strings.TrimSuffix(base, ext) + "-"
+	return dir, prefix, ext
+}
+
+type backupFile struct {
+	path string
+	t    time.Time
+	// seq is the counter of files rotated within the same millisecond
+	seq int
+}
+
+// millRun compresses rotated files and removes the ones exceeding
+// MaxBackups or MaxAge
+func (r *RotatingFile) millRun(now time.Time) {
+	r.mill.Lock()
+	defer r.mill.Unlock()
+
+	backups, err := r.backups()
+	if err != nil {
+		return
+	}
+
+	var keep []backupFile
+	for i, b := range backups {
+		if r.opts.MaxBackups > 0 && i >= r.opts.MaxBackups {
+			_ = os.Remove(b.path)
+			continue
+		}
+		if r.opts.MaxAge > 0 && now.Sub(b.t) > r.opts.MaxAge {
+			_ = os.Remove(b.path)
+			continue
+		}
+		keep = append(keep, b)
+	}
+
+	if !r.opts.Compress {
+		return
+	}
+	for _, b := range keep {
+		if strings.HasSuffix(b.path, ".gz") {
+			continue
+		}
+		if err := compressFile(b.path); err == nil {
+			_ = os.Remove(b.path)
+		}
+	}
+}
+
+// backups returns the rotated files, newest first
+func (r *RotatingFile) backups() ([]backupFile, error) {
+	dir, prefix, ext := r.nameParts()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var backups []backupFile
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasPrefix(e.Name(), prefix) {
+			continue
+		}
+		ts := strings.TrimPrefix(e.Name(), prefix)
+		ts = strings.TrimSuffix(ts, ".gz")
+		if !strings.HasSuffix(ts, ext) {
+			continue
+		}
+		ts = strings.TrimSuffix(ts, ext)
+		var seq int
+		if len(ts) > len(backupTimeFormat) {
+			n, err := strconv.Atoi(strings.TrimPrefix(ts[len(backupTimeFormat):], "."))
+			if err != nil || n <= 0 {
+				continue
+			}
+			ts, seq = ts[:len(backupTimeFormat)], n
+		}
+		t, err := time.ParseInLocation(backupTimeFormat, ts, time.Local)
+		if err != nil {
+			continue
+		}
+		backups = append(backups, backupFile{path: filepath.Join(dir, e.Name()), t: t, seq: seq})
+	}
+
+	slices.SortFunc(backups, func(a, b backupFile) int {
+		if c := b.t.Compare(a.t); c != 0 {
+			return c
+		}
+		return b.seq - a.seq
+	})
+	return backups, nil
+}
+
+func compressFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+
+	gz := gzip.NewWriter(dst)
+	if _, err := io.Copy(gz, src); err != nil {
+		_ = dst.Close()
+		_ = os.Remove(path + ".gz")
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		_ = dst.Close()
+		_ = os.Remove(path + ".gz")
+		return err
+	}
+	return dst.Close()
+}
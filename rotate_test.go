@@ -0,0 +1,166 @@
+package shandler
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func readDir(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	assert.Nil(t, err)
+
+	names := []string{}
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	return names
+}
+
+func TestRotatingFileSize(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.Local)
+
+	f := NewRotatingFile(filepath.Join(dir, "app.log"), RotatingFileOptions{MaxSize: 10, MaxBackups: 2})
+	f.now = func() time.Time { return now }
+
+	for _, line := range []string{"line 1\n", "line 2\n", "line 3\n", "line 4\n"} {
+		_, err := f.Write([]byte(line))
+		assert.Nil(t, err)
+		now = now.Add(time.Second)
+	}
+	assert.Nil(t, f.Close())
+
+	// every write after the first rotates, only the newest 2 backups are kept
+	assert.Equal(t, []string{"app-2024-01-02T03-04-07.000.log", "app-2024-01-02T03-04-08.000.log", "app.log"}, readDir(t, dir))
+
+	b, err := os.ReadFile(filepath.Join(dir, "app.log"))
+	assert.Nil(t, err)
+	assert.Equal(t, "line 4\n", string(b))
+}
+
+func TestRotatingFileSameTime(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.Local)
+
+	f := NewRotatingFile(filepath.Join(dir, "app.log"), RotatingFileOptions{MaxSize: 10})
+	f.now = func() time.Time { return now }
+
+	for i := 0; i < 20; i++ {
+		_, err := fmt.Fprintf(f, "line %d\n", i)
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, f.Close())
+
+	// the clock doesn't move, every backup gets its own counter
+	var lines []string
+	for _, name := range readDir(t, dir) {
+		b, err := os.ReadFile(filepath.Join(dir, name))
+		assert.Nil(t, err)
+		lines = append(lines, strings.TrimSpace(string(b)))
+	}
+	assert.Len(t, lines, 20)
+	assert.Contains(t, lines, "line 0")
+	assert.Contains(t, lines, "line 19")
+
+	backups, err := f.backups()
+	assert.Nil(t, err)
+	assert.Len(t, backups, 19)
+	assert.Equal(t, filepath.Join(dir, "app-2024-01-02T03-04-05.000.18.log"), backups[0].path)
+	assert.Equal(t, filepath.Join(dir, "app-2024-01-02T03-04-05.000.log"), backups[18].path)
+}
+
+func TestRotatingFileInterval(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2024, 1, 2, 3, 59, 0, 0, time.Local)
+
+	f := NewRotatingFile(filepath.Join(dir, "app.log"), RotatingFileOptions{Interval: RotateHourly, MaxAge: 30 * time.Minute})
+	f.now = func() time.Time { return now }
+
+	_, err := f.Write([]byte("hour 3\n"))
+	assert.Nil(t, err)
+	now = now.Add(time.Minute)
+	_, err = f.Write([]byte("hour 4\n"))
+	assert.Nil(t, err)
+	now = now.Add(time.Hour)
+	_, err = f.Write([]byte("hour 5\n"))
+	assert.Nil(t, err)
+	assert.Nil(t, f.Close())
+
+	// backups are named after the time they were rotated, the 04:00 backup
+	// is older than MaxAge when the file rotates at 05:00
+	assert.Equal(t, []string{"app-2024-01-02T05-00-00.000.log", "app.log"}, readDir(t, dir))
+}
+
+func TestRotatingFileCompress(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.Local)
+
+	f := NewRotatingFile(filepath.Join(dir, "app.log"), RotatingFileOptions{Compress: true})
+	f.now = func() time.Time { return now }
+
+	_, err := f.Write([]byte("compress me\n"))
+	assert.Nil(t, err)
+	assert.Nil(t, f.Rotate())
+	assert.Nil(t, f.Close())
+
+	assert.Equal(t, []string{"app-2024-01-02T03-04-05.000.log.gz", "app.log"}, readDir(t, dir))
+
+	gzf, err := os.Open(filepath.Join(dir, "app-2024-01-02T03-04-05.000.log.gz"))
+	assert.Nil(t, err)
+	defer gzf.Close()
+	gz, err := gzip.NewReader(gzf)
+	assert.Nil(t, err)
+	b, err := io.ReadAll(gz)
+	assert.Nil(t, err)
+	assert.Equal(t, "compress me\n", string(b))
+}
+
+func TestRotatingFileRotateEmpty(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.Local)
+
+	f := NewRotatingFile(filepath.Join(dir, "app.log"), RotatingFileOptions{})
+	f.now = func() time.Time { return now }
+
+	// nothing to rotate before the first write
+	assert.Nil(t, f.Rotate())
+	assert.Equal(t, []string{}, readDir(t, dir))
+
+	_, err := f.Write([]byte("line 1\n"))
+	assert.Nil(t, err)
+	assert.Nil(t, f.Rotate())
+	now = now.Add(time.Second)
+	// nor right after a rotation
+	assert.Nil(t, f.Rotate())
+	assert.Nil(t, f.Close())
+
+	assert.Equal(t, []string{"app-2024-01-02T03-04-05.000.log", "app.log"}, readDir(t, dir))
+}
+
+func TestWithFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs", "app.log")
+
+	h := NewHandler(WithFile(path, RotatingFileOptions{}))
+	logger := slog.New(h)
+	logger.Info("info")
+	logger.Error("error")
+
+	b, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	assert.Len(t, lines, 2)
+	assert.Contains(t, lines[0], "[INFO]")
+	assert.Contains(t, lines[1], "[ERROR]")
+
+	assert.Nil(t, h.Close())
+}
@@ -0,0 +1,152 @@
+package shandler
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SamplingOptions configures WithSampling. Within every Tick, the first
+// First records with a given level and message are logged, then every
+// Thereafter-th one. A Thereafter of 0 drops every record after the first
+// First
+type SamplingOptions struct {
+	// Tick is the interval the counts are reset at, one second when 0
+	Tick time.Duration `json:"tick"`
+	// First is the number of records logged per level and message in every tick
+	First uint64 `json:"first"`
+	// Thereafter logs every Thereafter-th record once First is reached
+	Thereafter uint64 `json:"thereafter"`
+	// PassErrors logs every ERROR record, FATAL records are always logged
+	PassErrors bool `json:"pass_errors"`
+}
+
+type sampleKey struct {
+	level slog.Level
+	msg   string
+}
+
+// sampler decides which records are logged by WithSampling. It is shared by a
+// handler and every handler derived from it. The number of records dropped
+// in a tick is reported by a summary record logged through root, the handler
+// created by NewHandler, as soon as the tick is over
+type sampler struct {
+	opts SamplingOptions
+	root *Handler
+
+	mu         sync.Mutex
+	tickStart  time.Time
+	counts     map[sampleKey]uint64
+	suppressed uint64
+	// timer logs the summary when the tick is over, gen tells a timer that
+	// fires late that its summary was already logged
+	timer *time.Timer
+	gen   uint64
+
+	total atomic.Uint64
+	now   func() time.Time
+}
+
+func newSampler(root *Handler, opts SamplingOptions) *sampler {
+	if opts.Tick <= 0 {
+		opts.Tick = time.Second
+	}
+	return &sampler{
+		opts:   opts,
+		root:   root,
+		counts: map[sampleKey]uint64{},
+		now:    time.Now,
+	}
+}
+
+// sample reports whether a record is logged
+func (s *sampler) sample(ctx context.Context, level slog.Level, msg string) bool {
+	s.mu.Lock()
+	now := s.now()
+	var suppressed uint64
+	if now.Sub(s.tickStart) >= s.opts.Tick {
+		suppressed = s.takeSuppressed()
+		s.tickStart = now
+		clear(s.counts)
+	}
+
+	ok := true
+	if !s.opts.PassErrors || level < slog.LevelError {
+		key := sampleKey{level: level, msg: msg}
+		count := s.counts[key] + 1
+		s.counts[key] = count
+		if count > s.opts.First && (s.opts.Thereafter == 0 || (count-s.opts.First)%s.opts.Thereafter != 0) {
+			ok = false
+			s.suppressed++
+			s.total.Add(1)
+			if s.suppressed == 1 {
+				gen := s.gen
+				s.timer = time.AfterFunc(s.tickStart.Add(s.opts.Tick).Sub(now), func() {
+					s.expire(gen)
+				})
+			}
+		}
+	}
+	s.mu.Unlock()
+
+	if suppressed > 0 {
+		s.summary(ctx, suppressed)
+	}
+	return ok
+}
+
+// expire logs the summary of the tick whose timer is generation gen
+func (s *sampler) expire(gen uint64) {
+	s.mu.Lock()
+	if gen != s.gen {
+		s.mu.Unlock()
+		return
+	}
+	suppressed := s.takeSuppressed()
+	s.mu.Unlock()
+
+	if suppressed > 0 {
+		s.summary(context.Background(), suppressed)
+	}
+}
+
+// flush logs the summary of the current tick, if records were dropped in it
+func (s *sampler) flush(ctx context.Context) {
+	s.mu.Lock()
+	suppressed := s.takeSuppressed()
+	s.mu.Unlock()
+
+	if suppressed > 0 {
+		s.summary(ctx, suppressed)
+	}
+}
+
+// takeSuppressed returns the number of records dropped since the last
+// summary and stops the timer that would log it
+func (s *sampler) takeSuppressed() uint64 {
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	s.gen++
+
+	suppressed := s.suppressed
+	s.suppressed = 0
+	return suppressed
+}
+
+func (s *sampler) summary(ctx context.Context, suppressed uint64) {
+	record := slog.NewRecord(s.now(), slog.LevelWarn, "records suppressed by sampling", 0)
+	record.AddAttrs(slog.Uint64("suppressed", suppressed), slog.Duration("tick", s.opts.Tick))
+	_ = s.root.handle(ctx, record)
+}
+
+// Suppressed returns the number of records dropped by WithSampling
+func (n *Handler) Suppressed() uint64 {
+	if n.sampler == nil {
+		return 0
+	}
+	return n.sampler.total.Load()
+}
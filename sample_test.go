@@ -0,0 +1,50 @@
+package shandler
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSamplerTick(t *testing.T) {
+	var stdout bytes.Buffer
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	h := NewHandler(WithStdOut(&stdout), WithTextOutputFormat("%[3]s\n"), WithSampling(SamplingOptions{Tick: time.Second, First: 1}))
+	h.sampler.now = func() time.Time { return now }
+	logger := slog.New(h)
+
+	logger.Info("a")
+	logger.Info("a")
+	logger.Debug("a")
+	logger.Warn("a")
+	now = now.Add(time.Second)
+	logger.Info("a")
+	logger.Info("a")
+	now = now.Add(time.Second)
+	logger.Info("b")
+
+	assert.Equal(t, "a\n"+
+		"a\n"+
+		"records suppressed by sampling suppressed=1 tick=1s\n"+
+		"a\n"+
+		"records suppressed by sampling suppressed=1 tick=1s\n"+
+		"b\n", stdout.String())
+	assert.Equal(t, uint64(2), h.Suppressed())
+}
+
+func TestSamplerFatal(t *testing.T) {
+	var stderr bytes.Buffer
+	h := NewHandler(WithStdErr(&stderr), WithTextOutputFormat("%[3]s\n"), WithSampling(SamplingOptions{Tick: time.Hour}))
+	logger := slog.New(h)
+
+	logger.Error("a")
+	logger.Log(context.TODO(), LevelFatal, "a")
+	logger.Log(context.TODO(), LevelFatal, "a")
+	assert.Equal(t, "a\na\n", stderr.String())
+	assert.Equal(t, uint64(1), h.Suppressed())
+}
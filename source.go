@@ -0,0 +1,159 @@
+package shandler
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+// LineInfoFormat controls how WithLineInfo reports the caller
+type LineInfoFormat int
+
+const (
+	// LineInfoShort writes the file base name and line, handler.go:12
+	LineInfoShort LineInfoFormat = iota
+	// LineInfoLong writes the function, full file path and line,
+	// disorder.dev/shandler.Func [/src/shandler/handler.go:12]
+	LineInfoLong
+	// LineInfoRelative writes the file path relative to the module root and
+	// line, internal/db/pool.go:12. Files outside the main module are
+	// written relative to the module cache, github.com/x/y@v1.0.0/y.go:12
+	LineInfoRelative
+	// LineInfoFunc writes the package and function name, shandler.Func
+	LineInfoFunc
+	// LineInfoSource writes a "source" group with the function, file and line
+	// keys used by slog.Source
+	LineInfoSource
+)
+
+var mainModule = func() string {
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		return bi.Main.Path
+	}
+	return ""
+}()
+
+// CallerPC returns the program counter of the function calling CallerPC,
+// skipping skip additional frames. Wrappers around a slog.Logger can use it
+// to build records that point to their own caller
+func CallerPC(skip int) uintptr {
+	var pcs [1]uintptr
+	// skip [runtime.Callers, CallerPC]
+	runtime.Callers(skip+2, pcs[:])
+	return pcs[0]
+}
+
+// NewRecord returns a slog.Record for the function calling NewRecord,
+// skipping skip additional frames. A wrapper called by user code passes 1
+func NewRecord(t time.Time, level slog.Level, msg string, skip int) slog.Record {
+	var pcs [1]uintptr
+	// skip [runtime.Callers, NewRecord]
+	runtime.Callers(skip+2, pcs[:])
+	return slog.NewRecord(t, level, msg, pcs[0])
+}
+
+// callerFrame returns the frame for pc. With WithCallerSkip, the frame is
+// found in the current stack and callerSkip frames further up are used
+// instead. This works because Handle runs on the goroutine that logged
+func (n *Handler) callerFrame(pc uintptr) runtime.Frame {
+	if n.callerSkip > 0 {
+		var pcs [64]uintptr
+		count := runtime.Callers(2, pcs[:])
+		for i := 0; i < count; i++ {
+			if pcs[i] == pc && i+n.callerSkip < count {
+				pc = pcs[i+n.callerSkip]
+				break
+			}
+		}
+	}
+
+	f, _ := runtime.CallersFrames([]uintptr{pc}).Next()
+	return f
+}
+
+// sourceAttr returns the caller attribute added by WithLineInfo. This was
+// adapted from stdlib record.go:219
+func (n *Handler) sourceAttr(pc uintptr) (slog.Attr, bool) {
+	if pc == 0 {
+		if n.lineInfoFormat == LineInfoSource {
+			return slog.Attr{}, false
+		}
+		return slog.String("slog_info", "unknown"), true
+	}
+
+	f := n.callerFrame(pc)
+
+	var logLine string
+	switch n.lineInfoFormat {
+	case LineInfoShort:
+		logLine = fmt.Sprintf("%s:%d", filepath.Base(f.File), f.Line)
+	case LineInfoLong:
+		logLine = fmt.Sprintf("%s [%s:%d]", f.Function, f.File, f.Line)
+	case LineInfoRelative:
+		logLine = fmt.Sprintf("%s:%d", relativeFile(f), f.Line)
+	case LineInfoFunc:
+		logLine = funcName(f.Function)
+	case LineInfoSource:
+		return slog.Group(slog.SourceKey,
+			slog.String("function", f.Function),
+			slog.String("file", f.File),
+			slog.Int("line", f.Line),
+		), true
+	}
+
+	if logLine == "" {
+		logLine = "unknown"
+	}
+	return slog.String("slog_info", logLine), true
+}
+
+// funcName trims the package path from a fully qualified function name,
+// disorder.dev/shandler.(*Handler).Handle becomes shandler.(*Handler).Handle
+func funcName(function string) string {
+	if i := strings.LastIndex(function, "/"); i >= 0 {
+		return function[i+1:]
+	}
+	return function
+}
+
+// packagePath returns the import path of the package a fully qualified
+// function name belongs to
+func packagePath(function string) string {
+	slash := strings.LastIndex(function, "/")
+	dot := strings.Index(function[slash+1:], ".")
+	if dot < 0 {
+		return ""
+	}
+	return function[:slash+1+dot]
+}
+
+// relativeFile returns the file of f relative to the root of its module
+func relativeFile(f runtime.Frame) string {
+	file := filepath.ToSlash(f.File)
+	if _, after, ok := strings.Cut(file, "/pkg/mod/"); ok {
+		return after
+	}
+
+	base := filepath.Base(file)
+	pkg := strings.TrimSuffix(packagePath(f.Function), "_test")
+	if mainModule != "" && (pkg == mainModule || strings.HasPrefix(pkg, mainModule+"/")) {
+		return path.Join(strings.TrimPrefix(strings.TrimPrefix(pkg, mainModule), "/"), base)
+	}
+	if pkg != "" && pkg != "main" {
+		return pkg + "/" + base
+	}
+
+	// the import path of package main is unknown, use the working directory
+	if wd, err := os.Getwd(); err == nil {
+		if rel, err := filepath.Rel(wd, f.File); err == nil && !strings.HasPrefix(rel, "..") {
+			return filepath.ToSlash(rel)
+		}
+	}
+	return base
+}
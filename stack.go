@@ -0,0 +1,112 @@
+package shandler
+
+import (
+	"log/slog"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// defaultStackDepth is the number of frames WithStackTrace keeps when no
+// depth is given
+const defaultStackDepth = 32
+
+// StackKey is the key of the attribute added by WithStackTrace
+const StackKey = "stack"
+
+// selfPackage is the import path of this package, its frames are left out of
+// stack traces along with the runtime and log/slog ones
+var selfPackage = func() string {
+	pc, _, _, _ := runtime.Caller(0)
+	return packagePath(runtime.FuncForPC(pc).Name())
+}()
+
+// StackFrame is a single frame of a stack trace
+type StackFrame struct {
+	Func string `json:"func"`
+	File string `json:"file"`
+	Line int    `json:"line"`
+}
+
+// Stack is the stack trace added to error records by WithStackTrace, the
+// innermost frame first
+type Stack []StackFrame
+
+// String returns the frames on a single line, separated by semicolons
+func (s Stack) String() string {
+	sb := strings.Builder{}
+	for i, f := range s {
+		if i > 0 {
+			sb.WriteString("; ")
+		}
+		sb.WriteString(f.Func)
+		sb.WriteByte(' ')
+		sb.WriteString(f.File)
+		sb.WriteByte(':')
+		sb.WriteString(strconv.Itoa(f.Line))
+	}
+	return sb.String()
+}
+
+// block returns the frames as an indented block for the text output, one
+// line for the function and one for its file and line
+func (s Stack) block() string {
+	sb := strings.Builder{}
+	for _, f := range s {
+		sb.WriteString("    ")
+		sb.WriteString(f.Func)
+		sb.WriteString("\n        ")
+		sb.WriteString(f.File)
+		sb.WriteByte(':')
+		sb.WriteString(strconv.Itoa(f.Line))
+		sb.WriteByte('\n')
+	}
+	return sb.String()
+}
+
+// stack captures the stack of the goroutine logging, starting at the log site
+// pc when it is found. Frames from the runtime, log/slog and this package are
+// skipped
+func (n *Handler) stack(pc uintptr) Stack {
+	depth := n.stackDepth
+	if depth <= 0 {
+		depth = defaultStackDepth
+	}
+
+	pcs := make([]uintptr, depth+64)
+	// skip [runtime.Callers, stack]
+	pcs = pcs[:runtime.Callers(2, pcs)]
+	if i := slices.Index(pcs, pc); i >= 0 && i+n.callerSkip < len(pcs) {
+		pcs = pcs[i+n.callerSkip:]
+	}
+
+	s := make(Stack, 0, depth)
+	frames := runtime.CallersFrames(pcs)
+	for len(s) < depth {
+		f, more := frames.Next()
+		switch packagePath(f.Function) {
+		case "", "runtime", "log/slog", selfPackage:
+		default:
+			s = append(s, StackFrame{Func: f.Function, File: f.File, Line: f.Line})
+		}
+		if !more {
+			break
+		}
+	}
+	return s
+}
+
+// splitStack removes the top level stack attribute from attrs and returns it
+// so the text output can write it on its own lines
+func splitStack(attrs []slog.Attr) ([]slog.Attr, Stack) {
+	for i, a := range attrs {
+		if a.Value.Kind() != slog.KindAny {
+			continue
+		}
+		if s, ok := a.Value.Any().(Stack); ok {
+			return slices.Delete(attrs, i, i+1), s
+		}
+	}
+	return attrs, nil
+}
@@ -0,0 +1,222 @@
+package shandler
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SyslogFormat is the syslog message format written by WithSyslog
+type SyslogFormat int
+
+const (
+	// SyslogRFC5424 writes <PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID
+	// [STRUCTURED-DATA] MSG, with the attributes as structured data
+	SyslogRFC5424 SyslogFormat = iota
+	// SyslogRFC3164 writes the BSD format, <PRI>Mmm dd hh:mm:ss HOSTNAME
+	// TAG[PID]: MSG, with the attributes appended to MSG as key=value pairs
+	SyslogRFC3164
+)
+
+// SyslogFacility is the syslog facility of the records
+type SyslogFacility int
+
+const (
+	FacilityUser SyslogFacility = iota + 1
+	FacilityMail
+	FacilityDaemon
+	FacilityAuth
+	FacilitySyslog
+	FacilityLPR
+	FacilityNews
+	FacilityUUCP
+	FacilityCron
+	FacilityAuthPriv
+	FacilityFTP
+)
+
+const (
+	FacilityLocal0 SyslogFacility = iota + 16
+	FacilityLocal1
+	FacilityLocal2
+	FacilityLocal3
+	FacilityLocal4
+	FacilityLocal5
+	FacilityLocal6
+	FacilityLocal7
+)
+
+// defaultSyslogSDID is the SD-ID of the structured data element holding the
+// attributes, 32473 is the private enterprise number reserved for examples
+const defaultSyslogSDID = "attrs@32473"
+
+// SyslogOptions configures WithSyslog
+type SyslogOptions struct {
+	Format SyslogFormat `json:"format"`
+	// Facility defaults to FacilityUser
+	Facility SyslogFacility `json:"facility"`
+	// AppName defaults to the name of the executable
+	AppName string `json:"app_name"`
+	// Hostname defaults to os.Hostname
+	Hostname string `json:"hostname"`
+	// MsgID identifies the type of the records, RFC 5424 only
+	MsgID string `json:"msg_id"`
+	// StructuredDataID is the SD-ID of the element holding the attributes,
+	// RFC 5424 only. It defaults to attrs@32473
+	StructuredDataID string `json:"structured_data_id"`
+}
+
+// syslogSeverity maps a level to a syslog severity
+func syslogSeverity(l slog.Level) int {
+	switch {
+	case l >= LevelFatal:
+		return 2 // critical
+	case l >= slog.LevelError:
+		return 3 // error
+	case l >= slog.LevelWarn:
+		return 4 // warning
+	case l > slog.LevelInfo:
+		return 5 // notice
+	case l == slog.LevelInfo:
+		return 6 // informational
+	}
+	return 7 // debug
+}
+
+// appendSyslog writes record in the syslog format of opts followed by a
+// newline. The syslog writers remove the newline when they frame a message
+func appendSyslog(buf []byte, opts *SyslogOptions, level slog.Level, t time.Time, message, pid string, attrs []slog.Attr) []byte {
+	buf = append(buf, '<')
+	buf = strconv.AppendInt(buf, int64(opts.Facility)*8+int64(syslogSeverity(level)), 10)
+	buf = append(buf, '>')
+
+	if opts.Format == SyslogRFC3164 {
+		if t.IsZero() {
+			t = time.Now()
+		}
+		buf = t.AppendFormat(buf, time.Stamp)
+		buf = append(buf, ' ')
+		buf = append(buf, syslogField(opts.Hostname, 255)...)
+		buf = append(buf, ' ')
+		buf = append(buf, syslogField(opts.AppName, 32)...)
+		if pid != "" {
+			buf = append(buf, '[')
+			buf = append(buf, pid...)
+			buf = append(buf, ']')
+		}
+		buf = append(buf, ':', ' ')
+		buf = append(buf, message...)
+		if len(attrs) != 0 {
+			sb := strings.Builder{}
+			logfmtAttrs(&sb, "", attrs)
+			buf = append(buf, ' ')
+			buf = append(buf, sb.String()...)
+		}
+		return append(buf, '\n')
+	}
+
+	buf = append(buf, '1', ' ')
+	if t.IsZero() {
+		buf = append(buf, '-')
+	} else {
+		buf = t.AppendFormat(buf, "2006-01-02T15:04:05.000000Z07:00")
+	}
+	buf = append(buf, ' ')
+	buf = append(buf, syslogField(opts.Hostname, 255)...)
+	buf = append(buf, ' ')
+	buf = append(buf, syslogField(opts.AppName, 48)...)
+	buf = append(buf, ' ')
+	buf = append(buf, syslogField(pid, 128)...)
+	buf = append(buf, ' ')
+	buf = append(buf, syslogField(opts.MsgID, 32)...)
+	buf = append(buf, ' ')
+	if len(attrs) == 0 {
+		buf = append(buf, '-')
+	} else {
+		buf = append(buf, '[')
+		buf = append(buf, syslogName(opts.StructuredDataID)...)
+		buf = appendSyslogParams(buf, "", attrs)
+		buf = append(buf, ']')
+	}
+	if message != "" {
+		buf = append(buf, ' ')
+		buf = append(buf, message...)
+	}
+	return append(buf, '\n')
+}
+
+// appendSyslogParams writes attrs as SD-PARAMs, flattening groups into dotted
+// names
+func appendSyslogParams(buf []byte, prefix string, attrs []slog.Attr) []byte {
+	for _, a := range attrs {
+		if a.Value.Kind() == slog.KindGroup {
+			buf = appendSyslogParams(buf, prefix+a.Key+".", a.Value.Group())
+			continue
+		}
+		buf = append(buf, ' ')
+		buf = append(buf, syslogName(prefix+a.Key)...)
+		buf = append(buf, '=', '"')
+		for _, c := range []byte(a.Value.String()) {
+			// PARAM-VALUE escapes '"', '\' and ']'
+			if c == '"' || c == '\\' || c == ']' {
+				buf = append(buf, '\\')
+			}
+			buf = append(buf, c)
+		}
+		buf = append(buf, '"')
+	}
+	return buf
+}
+
+// syslogField returns s as a header field: printable US-ASCII without
+// spaces, at most size characters, or the nil value - when empty
+func syslogField(s string, size int) string {
+	if s == "" {
+		return "-"
+	}
+	s = strings.Map(func(r rune) rune {
+		if r <= ' ' || r > '~' {
+			return '_'
+		}
+		return r
+	}, s)
+	if len(s) > size {
+		s = s[:size]
+	}
+	return s
+}
+
+// syslogName returns s as an SD-NAME, a header field that also excludes
+// '=', ']' and '"' and is at most 32 characters
+func syslogName(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if r == '=' || r == ']' || r == '"' {
+			return '_'
+		}
+		return r
+	}, s)
+	if s == "" {
+		return "_"
+	}
+	return syslogField(s, 32)
+}
+
+// syslogDefaults fills in the options left empty
+func syslogDefaults(opts SyslogOptions) SyslogOptions {
+	if opts.Facility <= 0 {
+		opts.Facility = FacilityUser
+	}
+	if opts.AppName == "" {
+		opts.AppName = filepath.Base(os.Args[0])
+	}
+	if opts.Hostname == "" {
+		opts.Hostname, _ = os.Hostname()
+	}
+	if opts.StructuredDataID == "" {
+		opts.StructuredDataID = defaultSyslogSDID
+	}
+	return opts
+}
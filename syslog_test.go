@@ -0,0 +1,245 @@
+package shandler
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSyslogRFC5424(t *testing.T) {
+	var stdout bytes.Buffer
+	h := NewHandler(WithStdOut(&stdout), WithPid(), WithSyslog(SyslogOptions{AppName: "app", Hostname: "host", MsgID: "req", Facility: FacilityLocal0}))
+
+	record := slog.NewRecord(time.Date(2024, 1, 2, 3, 4, 5, 6000, time.UTC), slog.LevelInfo, "hello", 0)
+	record.AddAttrs(slog.String("user", "bob"), slog.Group("req", slog.String("path", `/a"]`)))
+	assert.Nil(t, h.WithGroup("g").Handle(context.TODO(), record))
+	assert.Equal(t, fmt.Sprintf("<134>1 2024-01-02T03:04:05.000006Z host app %d req [attrs@32473 g.user=\"bob\" g.req.path=\"/a\\\"\\]\"] hello\n", os.Getpid()), stdout.String())
+
+	stdout.Reset()
+	h = NewHandler(WithStdOut(&stdout), WithSyslog(SyslogOptions{AppName: "my app", Hostname: "host"}))
+	assert.Nil(t, h.Handle(context.TODO(), slog.NewRecord(time.Time{}, slog.LevelDebug-1, "", 0)))
+	assert.Equal(t, "<15>1 - host my_app - - -\n", stdout.String())
+}
+
+func TestSyslogRFC3164(t *testing.T) {
+	var stderr bytes.Buffer
+	h := NewHandler(WithStdErr(&stderr), WithSyslog(SyslogOptions{Format: SyslogRFC3164, AppName: "app", Hostname: "host", Facility: FacilityLocal0}))
+
+	record := slog.NewRecord(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), slog.LevelError, "failed", 0)
+	record.AddAttrs(slog.String("user", "bob smith"))
+	assert.Nil(t, h.Handle(context.TODO(), record))
+	assert.Equal(t, "<131>Jan  2 03:04:05 host app: failed user=\"bob smith\"\n", stderr.String())
+}
+
+func TestSyslogReplaceAttr(t *testing.T) {
+	var stdout bytes.Buffer
+	h := NewHandler(WithStdOut(&stdout), WithSyslog(SyslogOptions{AppName: "app", Hostname: "host"}), WithReplaceAttr(func(groups []string, a slog.Attr) slog.Attr {
+		switch a.Key {
+		case slog.LevelKey:
+			return slog.Any("severity", slog.LevelError)
+		case slog.TimeKey:
+			return slog.Attr{}
+		case slog.MessageKey:
+			return slog.String("msg", "replaced")
+		}
+		return a
+	}))
+
+	assert.Nil(t, h.Handle(context.TODO(), slog.NewRecord(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), slog.LevelInfo, "hello", 0)))
+	assert.Equal(t, "<11>1 - host app - - - replaced\n", stdout.String())
+}
+
+func TestSyslogSeverity(t *testing.T) {
+	for level, severity := range map[slog.Level]int{
+		LevelTrace:          7,
+		slog.LevelDebug:     7,
+		slog.LevelInfo:      6,
+		slog.LevelInfo + 2:  5,
+		slog.LevelWarn:      4,
+		slog.LevelError:     3,
+		slog.LevelError + 1: 3,
+		LevelFatal:          2,
+	} {
+		assert.Equal(t, severity, syslogSeverity(level), level.String())
+	}
+}
+
+func TestSyslogWriterUDP(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer pc.Close()
+
+	w := NewSyslogWriter("udp", pc.LocalAddr().String())
+	defer w.Close()
+	_, err = w.Write([]byte("<14>1 - - - - - - one\n"))
+	assert.Nil(t, err)
+
+	assert.Nil(t, pc.SetReadDeadline(time.Now().Add(5*time.Second)))
+	b := make([]byte, 1024)
+	n, _, err := pc.ReadFrom(b)
+	assert.Nil(t, err)
+	assert.Equal(t, "<14>1 - - - - - - one", string(b[:n]))
+}
+
+// readOctetCounted reads a message framed with octet counting
+func readOctetCounted(t *testing.T, r *bufio.Reader) string {
+	t.Helper()
+	size, err := r.ReadString(' ')
+	assert.Nil(t, err)
+	n, err := strconv.Atoi(strings.TrimSpace(size))
+	assert.Nil(t, err)
+	b := make([]byte, n)
+	_, err = io.ReadFull(r, b)
+	assert.Nil(t, err)
+	return string(b)
+}
+
+func TestSyslogWriterTCPReconnect(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer ln.Close()
+
+	conns := make(chan net.Conn)
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				close(conns)
+				return
+			}
+			conns <- c
+		}
+	}()
+
+	w := NewSyslogWriter("tcp", ln.Addr().String())
+	defer w.Close()
+	h := NewHandler(WithStdOut(w), WithSyslog(SyslogOptions{AppName: "app", Hostname: "host"}))
+	logger := slog.New(h)
+
+	logger.Info("one", "multi", "line\nvalue")
+	c1 := <-conns
+	assert.Contains(t, readOctetCounted(t, bufio.NewReader(c1)), "app - - [attrs@32473 multi=\"line\nvalue\"] one")
+
+	// the server goes away, the next write connects again
+	assert.Nil(t, c1.Close())
+	assert.Eventually(t, func() bool {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		return w.broken.Load()
+	}, 5*time.Second, 5*time.Millisecond)
+
+	logger.Info("two")
+	c2 := <-conns
+	defer c2.Close()
+	assert.True(t, strings.HasSuffix(readOctetCounted(t, bufio.NewReader(c2)), "app - - - two"))
+}
+
+func TestSyslogWriterUnix(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("unixgram sockets are not supported on windows")
+	}
+
+	// socket paths are limited to ~100 characters, t.TempDir is too long on macOS
+	dir, err := os.MkdirTemp("", "syslog")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "log.sock")
+
+	pc, err := net.ListenPacket("unixgram", path)
+	assert.Nil(t, err)
+	defer pc.Close()
+
+	w := NewSyslogWriter("unix", path)
+	defer w.Close()
+	_, err = w.Write([]byte("<14>1 - - - - - - one\n"))
+	assert.Nil(t, err)
+
+	assert.Nil(t, pc.SetReadDeadline(time.Now().Add(5*time.Second)))
+	b := make([]byte, 1024)
+	n, _, err := pc.ReadFrom(b)
+	assert.Nil(t, err)
+	assert.Equal(t, "<14>1 - - - - - - one", string(b[:n]))
+}
+
+func TestSyslogWriterBackoff(t *testing.T) {
+	// a port nothing listens on
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	addr := ln.Addr().String()
+	assert.Nil(t, ln.Close())
+
+	w := NewSyslogWriter("tcp", addr)
+	defer w.Close()
+	_, err = w.Write([]byte("<14>1 - - - - - - one\n"))
+	assert.NotNil(t, err)
+	assert.NotErrorIs(t, err, errSyslogBackoff)
+
+	// the next writes fail without dialing until the backoff is over
+	_, err = w.Write([]byte("<14>1 - - - - - - two\n"))
+	assert.ErrorIs(t, err, errSyslogBackoff)
+	assert.Equal(t, syslogMinBackoff, w.backoff)
+
+	w.nextDial = time.Time{}
+	_, err = w.Write([]byte("<14>1 - - - - - - three\n"))
+	assert.NotErrorIs(t, err, errSyslogBackoff)
+	assert.Equal(t, 2*syslogMinBackoff, w.backoff)
+
+	// a connection resets the backoff
+	ln, err = net.Listen("tcp", addr)
+	if err != nil {
+		t.Skip("port was taken:", err)
+	}
+	defer ln.Close()
+	w.nextDial = time.Time{}
+	_, err = w.Write([]byte("<14>1 - - - - - - four\n"))
+	assert.Nil(t, err)
+	assert.Zero(t, w.backoff)
+}
+
+func TestSyslogWriterUnixStream(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("unix sockets are not used for syslog on windows")
+	}
+
+	dir, err := os.MkdirTemp("", "syslog")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "log.sock")
+
+	ln, err := net.Listen("unix", path)
+	assert.Nil(t, err)
+	defer ln.Close()
+
+	w := NewSyslogWriter("unix", path)
+	defer w.Close()
+	h := NewHandler(WithStdOut(w), WithSyslog(SyslogOptions{AppName: "app", Hostname: "host"}))
+	slog.New(h).Info("one", "multi", "line\nvalue")
+	slog.New(h).Info("two")
+
+	c, err := ln.Accept()
+	assert.Nil(t, err)
+	defer c.Close()
+	assert.Nil(t, c.SetReadDeadline(time.Now().Add(5*time.Second)))
+	r := bufio.NewReader(c)
+
+	// the newline in the value doesn't split the message
+	line, err := r.ReadString('\n')
+	assert.Nil(t, err)
+	assert.True(t, strings.HasSuffix(line, "app - - [attrs@32473 multi=\"line#012value\"] one\n"), line)
+	line, err = r.ReadString('\n')
+	assert.Nil(t, err)
+	assert.True(t, strings.HasSuffix(line, "app - - - two\n"), line)
+}
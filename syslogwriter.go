@@ -0,0 +1,165 @@
+package shandler
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// syslogDialTimeout bounds how long a SyslogWriter waits for a connection
+const syslogDialTimeout = 5 * time.Second
+
+// After a failed connection a SyslogWriter waits before dialing again,
+// doubling the delay from syslogMinBackoff up to syslogMaxBackoff
+const (
+	syslogMinBackoff = 500 * time.Millisecond
+	syslogMaxBackoff = 30 * time.Second
+)
+
+// errSyslogBackoff is returned by writes made while a SyslogWriter waits to
+// connect again
+var errSyslogBackoff = errors.New("syslog: server unreachable, waiting to reconnect")
+
+// SyslogWriter is an io.WriteCloser sending every Write as a syslog message
+// to a syslog server, e.g. rsyslog. Use it with WithSyslog:
+//
+//	w := shandler.NewSyslogWriter("udp", "localhost:514")
+//	h := shandler.NewHandler(shandler.WithSyslog(shandler.SyslogOptions{}), shandler.WithStdOut(w), shandler.WithStdErr(w))
+//
+// Messages are framed for the network: over tcp with octet counting
+// (RFC 6587), one message per datagram over udp and unixgram, and newline
+// terminated over unix stream sockets. The "unix" network tries a datagram
+// socket first, like /dev/log, then a stream socket.
+//
+// The connection is made on the first Write and made again when a write
+// fails or the server closes it, so creating a SyslogWriter never fails.
+// When the server can't be reached, writes fail right away until a backoff
+// delay has passed instead of waiting for a connection each time, so logging
+// doesn't block while the server is down. Newlines in messages sent over
+// newline framed unix stream sockets are escaped as #012, like rsyslog does.
+// It is safe for concurrent use
+type SyslogWriter struct {
+	network string
+	addr    string
+
+	mu     sync.Mutex
+	conn   net.Conn
+	stream bool
+	// broken is set by the goroutine watching a stream connection when the
+	// server closes it
+	broken *atomic.Bool
+	// nextDial is when the next connection may be attempted after backoff
+	// failed ones
+	nextDial time.Time
+	backoff  time.Duration
+}
+
+// NewSyslogWriter returns a SyslogWriter sending to addr over network, one of
+// udp, tcp, unix, unixgram and their variants such as tcp4
+func NewSyslogWriter(network, addr string) *SyslogWriter {
+	return &SyslogWriter{network: network, addr: addr}
+}
+
+func (w *SyslogWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	msg := bytes.TrimSuffix(p, []byte("\n"))
+
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if w.conn != nil && w.broken.Load() {
+			w.closeConn()
+		}
+		if w.conn == nil {
+			if time.Now().Before(w.nextDial) {
+				return 0, errSyslogBackoff
+			}
+			if err = w.connect(); err != nil {
+				w.backoff = min(max(2*w.backoff, syslogMinBackoff), syslogMaxBackoff)
+				w.nextDial = time.Now().Add(w.backoff)
+				return 0, err
+			}
+			w.backoff = 0
+		}
+		if _, err = w.conn.Write(w.frame(msg)); err == nil {
+			return len(p), nil
+		}
+		w.closeConn()
+	}
+	return 0, err
+}
+
+// Close closes the connection. A later Write connects again
+func (w *SyslogWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.conn == nil {
+		return nil
+	}
+	return w.closeConn()
+}
+
+func (w *SyslogWriter) frame(msg []byte) []byte {
+	if !w.stream {
+		return msg
+	}
+	if strings.HasPrefix(w.network, "tcp") {
+		b := make([]byte, 0, len(msg)+8)
+		b = strconv.AppendInt(b, int64(len(msg)), 10)
+		b = append(b, ' ')
+		return append(b, msg...)
+	}
+	// unix stream sockets are newline framed, a newline in msg would split it
+	return append(bytes.ReplaceAll(msg, []byte("\n"), []byte("#012")), '\n')
+}
+
+func (w *SyslogWriter) connect() error {
+	var conn net.Conn
+	var err error
+	switch w.network {
+	case "unix":
+		if conn, err = net.DialTimeout("unixgram", w.addr, syslogDialTimeout); err != nil {
+			conn, err = net.DialTimeout("unix", w.addr, syslogDialTimeout)
+		}
+	default:
+		conn, err = net.DialTimeout(w.network, w.addr, syslogDialTimeout)
+	}
+	if err != nil {
+		return err
+	}
+
+	w.conn = conn
+	w.broken = new(atomic.Bool)
+	w.stream = false
+	switch conn.LocalAddr().Network() {
+	case "tcp", "tcp4", "tcp6", "unix":
+		w.stream = true
+		go watchConn(conn, w.broken)
+	}
+	return nil
+}
+
+// watchConn sets broken once conn is closed. Syslog servers never write to
+// the client, so the read only returns when the connection is gone
+func watchConn(conn net.Conn, broken *atomic.Bool) {
+	var b [1]byte
+	for {
+		if _, err := conn.Read(b[:]); err != nil {
+			broken.Store(true)
+			return
+		}
+	}
+}
+
+func (w *SyslogWriter) closeConn() error {
+	err := w.conn.Close()
+	w.conn = nil
+	return err
+}
@@ -0,0 +1,136 @@
+package shandler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// Keys of the trace correlation attributes added by WithTraceContext, the
+// names used by the OpenTelemetry log data model
+const (
+	TraceIDKey    = "trace_id"
+	SpanIDKey     = "span_id"
+	TraceFlagsKey = "trace_flags"
+)
+
+// TraceContext identifies the span a record was logged in
+type TraceContext struct {
+	// TraceID is the 32 character lowercase hex trace ID
+	TraceID string
+	// SpanID is the 16 character lowercase hex span ID
+	SpanID string
+	// TraceFlags holds the W3C trace flags, 0x01 when the trace is sampled
+	TraceFlags byte
+}
+
+// TraceExtractor returns the trace context carried by ctx, if any. It lets
+// the handler correlate records with traces from any tracing library without
+// depending on it
+type TraceExtractor interface {
+	Extract(ctx context.Context) (TraceContext, bool)
+}
+
+// TraceExtractorFunc adapts a function to a TraceExtractor, e.g. for the
+// OpenTelemetry SDK:
+//
+//	shandler.TraceExtractorFunc(func(ctx context.Context) (shandler.TraceContext, bool) {
+//		sc := trace.SpanContextFromContext(ctx)
+//		return shandler.TraceContext{
+//			TraceID:    sc.TraceID().String(),
+//			SpanID:     sc.SpanID().String(),
+//			TraceFlags: byte(sc.TraceFlags()),
+//		}, sc.IsValid()
+//	})
+type TraceExtractorFunc func(ctx context.Context) (TraceContext, bool)
+
+func (f TraceExtractorFunc) Extract(ctx context.Context) (TraceContext, bool) {
+	return f(ctx)
+}
+
+// TraceparentExtractor extracts the W3C traceparent stored in the context
+// with ContextWithTraceparent
+var TraceparentExtractor TraceExtractor = TraceExtractorFunc(func(ctx context.Context) (TraceContext, bool) {
+	tp, ok := ctx.Value(traceparentKey{}).(string)
+	if !ok {
+		return TraceContext{}, false
+	}
+	tc, err := ParseTraceparent(tp)
+	return tc, err == nil
+})
+
+type traceparentKey struct{}
+
+// ContextWithTraceparent returns a copy of ctx carrying a W3C traceparent
+// value, such as the traceparent header of an incoming request. It is parsed
+// when a record is logged, invalid values add no attributes
+func ContextWithTraceparent(ctx context.Context, traceparent string) context.Context {
+	return context.WithValue(ctx, traceparentKey{}, traceparent)
+}
+
+var errInvalidTraceparent = errors.New("invalid traceparent")
+
+// ParseTraceparent parses a W3C traceparent value,
+// version-traceid-parentid-flags, e.g.
+// 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01
+func ParseTraceparent(s string) (TraceContext, error) {
+	s = strings.TrimSpace(s)
+	// version 00 is exactly 55 characters, later versions may add fields
+	if len(s) < 55 || (len(s) > 55 && s[55] != '-') || s[2] != '-' || s[35] != '-' || s[52] != '-' {
+		return TraceContext{}, errInvalidTraceparent
+	}
+
+	version, traceID, spanID, flags := s[0:2], s[3:35], s[36:52], s[53:55]
+	if !isLowerHex(version) || version == "ff" || (version == "00" && len(s) != 55) {
+		return TraceContext{}, fmt.Errorf("%w: version %q", errInvalidTraceparent, version)
+	}
+	if !isLowerHex(traceID) || strings.Trim(traceID, "0") == "" {
+		return TraceContext{}, fmt.Errorf("%w: trace id %q", errInvalidTraceparent, traceID)
+	}
+	if !isLowerHex(spanID) || strings.Trim(spanID, "0") == "" {
+		return TraceContext{}, fmt.Errorf("%w: parent id %q", errInvalidTraceparent, spanID)
+	}
+	if !isLowerHex(flags) {
+		return TraceContext{}, fmt.Errorf("%w: flags %q", errInvalidTraceparent, flags)
+	}
+
+	return TraceContext{
+		TraceID:    traceID,
+		SpanID:     spanID,
+		TraceFlags: unhex(flags[0])<<4 | unhex(flags[1]),
+	}, nil
+}
+
+func isLowerHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if !('0' <= s[i] && s[i] <= '9' || 'a' <= s[i] && s[i] <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func unhex(c byte) byte {
+	if c >= 'a' {
+		return c - 'a' + 10
+	}
+	return c - '0'
+}
+
+// traceAttrs returns the trace correlation attributes for ctx
+func (n *Handler) traceAttrs(ctx context.Context) []slog.Attr {
+	if n.traceExtractor == nil || ctx == nil {
+		return nil
+	}
+	tc, ok := n.traceExtractor.Extract(ctx)
+	if !ok {
+		return nil
+	}
+	return []slog.Attr{
+		slog.String(TraceIDKey, tc.TraceID),
+		slog.String(SpanIDKey, tc.SpanID),
+		slog.String(TraceFlagsKey, string([]byte{hex[tc.TraceFlags>>4], hex[tc.TraceFlags&0xF]})),
+	}
+}